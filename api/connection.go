@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ilikerice123/puzzle/game"
+)
+
+// SlowClientPolicy decides what happens to a websocket client whose outbound
+// queue fills up because it can't keep up with the puzzle's updates
+type SlowClientPolicy int
+
+// policies for slow clients
+const (
+	// DropAndResync drops everything queued for the client, and queues a
+	// snapshot of the puzzle instead, followed by the updates sent while it
+	// was taken
+	DropAndResync SlowClientPolicy = iota
+	// Disconnect closes the client's connection
+	Disconnect
+)
+
+// SlowClients is the policy applied to clients that fall behind
+var SlowClients = DropAndResync
+
+// ParseSlowClientPolicy returns the policy named name, "resync" for
+// DropAndResync or "disconnect" for Disconnect
+func ParseSlowClientPolicy(name string) (SlowClientPolicy, error) {
+	switch name {
+	case "resync":
+		return DropAndResync, nil
+	case "disconnect":
+		return Disconnect, nil
+	default:
+		return DropAndResync, fmt.Errorf("unknown slow client policy %q", name)
+	}
+}
+
+// OutboundQueueSize is how many messages can be queued for a single client.
+// It fits every update a resuming client can be replayed, with room for the
+// ones made while they are
+var OutboundQueueSize = game.UpdateLogSize + 256
+
+const (
+	// time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// time allowed to read the next pong message from the client
+	pongWait = 60 * time.Second
+	// pings are sent with this period, which must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// maximum size of a message from the client
+	maxMessageSize = 4096
+)
+
+// connection is a single websocket client of a puzzle, with its own queue of
+// outbound messages written by its own goroutine, so a slow client can't
+// block the puzzle's updates
+type connection struct {
+	conn      *websocket.Conn
+	puzzle    game.LivePuzzleBase
+	send      chan message
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	// lock guards queueing messages. While the client is being resynced,
+	// updates and acks are held back in pending until the snapshot is queued
+	lock      sync.Mutex
+	resyncing bool
+	pending   []interface{}
+}
+
+// message is a serialized message queued for the client
+type message struct {
+	data []byte
+	// acks are kept when the queue is dropped for a resync, since the
+	// snapshot doesn't answer the requests they are for
+	ack *game.Ack
+}
+
+// newConnection creates a connection, and sets up its read deadlines and
+// keepalives
+func newConnection(c *websocket.Conn, p game.LivePuzzleBase) *connection {
+	c.SetReadLimit(maxMessageSize)
+	c.SetReadDeadline(time.Now().Add(pongWait))
+	c.SetPongHandler(func(string) error {
+		c.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connection{
+		conn:   c,
+		puzzle: p,
+		send:   make(chan message, OutboundQueueSize),
+		ctx:    ctx,
+		cancel: cancel}
+}
+
+// read reads the next text message from the client
+func (c *connection) read() ([]byte, error) {
+	msgType, msg, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	if msgType != websocket.TextMessage {
+		return nil, errors.New("unexpected websocket message type")
+	}
+	return msg, nil
+}
+
+// push queues an update to be sent to the client
+func (c *connection) push(u *game.Update) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pushLocked(u)
+}
+
+// pushLocked queues an update or an ack, or holds it back while the client is
+// being resynced. lock must be held
+func (c *connection) pushLocked(v interface{}) {
+	if c.resyncing {
+		c.pending = append(c.pending, v)
+		return
+	}
+	c.queue(v)
+}
+
+// reply queues an ack to be sent to the client
+func (c *connection) reply(a *game.Ack) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pushLocked(a)
+}
+
+// queue queues a message to be sent to the client without blocking, applying
+// the SlowClients policy if the queue is full. lock must be held
+func (c *connection) queue(v interface{}) {
+	serialized, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	ack, _ := v.(*game.Ack)
+	select {
+	case c.send <- message{data: serialized, ack: ack}:
+		return
+	default:
+	}
+
+	if SlowClients == Disconnect {
+		c.close()
+		return
+	}
+	// drop every queued update, and send the whole puzzle instead. Acks are
+	// held back to be queued again after the snapshot
+	for len(c.send) > 0 {
+		select {
+		case m := <-c.send:
+			if m.ack != nil {
+				c.pending = append(c.pending, m.ack)
+			}
+		default:
+		}
+	}
+	if ack != nil {
+		c.pending = append(c.pending, ack)
+	}
+	if !c.resyncing {
+		c.resyncing = true
+		// the snapshot is taken by the puzzle, which waits for the update
+		// being pushed to be sent out first
+		go c.resync()
+	}
+}
+
+// resync queues a snapshot of the puzzle, followed by the acks held back, and
+// the updates held back while it was taken that it doesn't include
+func (c *connection) resync() {
+	snapshot, nextID := c.puzzle.Snapshot()
+	if snapshot == nil {
+		// the puzzle was stopped
+		c.close()
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pending := c.pending
+	c.pending, c.resyncing = nil, false
+	c.queue(snapshot)
+	for _, v := range pending {
+		if u, ok := v.(*game.Update); ok && u.ID < nextID {
+			continue
+		}
+		c.pushLocked(v)
+	}
+}
+
+// writePump writes queued messages and pings to the client until the
+// connection is closed
+func (c *connection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	defer c.close()
+	for {
+		select {
+		case m := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, m.data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// reject closes the connection because the user couldn't join, with the code
+// and message of the error they were rejected with as the reason
+func (c *connection) reject(err error) {
+	reason := string(game.CodeOf(err)) + ": " + err.Error()
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+	c.close()
+}
+
+// close closes the connection, which also stops its writer and reader, and
+// closes every subscription made with the connection's context
+func (c *connection) close() {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.conn.Close()
+	})
+}
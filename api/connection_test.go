@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ilikerice123/puzzle/game"
+)
+
+// snapshotter is a puzzle whose snapshots are taken once release is closed
+type snapshotter struct {
+	game.LivePuzzleBase
+	release chan struct{}
+	nextID  int
+}
+
+func (s *snapshotter) Snapshot() (*game.Update, int) {
+	<-s.release
+	return &game.Update{Action: game.SNAPSHOT, ID: -1}, s.nextID
+}
+
+// received reads the next message queued for a connection
+func received(t *testing.T, c *connection) map[string]interface{} {
+	t.Helper()
+	m := <-c.send
+	var v map[string]interface{}
+	if err := json.Unmarshal(m.data, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestResyncKeepsAcks(t *testing.T) {
+	SlowClients = DropAndResync
+	puzzle := &snapshotter{release: make(chan struct{}), nextID: 5}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &connection{puzzle: puzzle, send: make(chan message, 4), ctx: ctx, cancel: cancel}
+
+	c.push(&game.Update{Action: game.SWAP, ID: 0})
+	c.reply(&game.Ack{Action: game.ACK, RequestID: "a", UpdateID: 0})
+	c.push(&game.Update{Action: game.SWAP, ID: 1})
+	c.push(&game.Update{Action: game.SWAP, ID: 2})
+	// the queue overflows, and is dropped while the snapshot is taken
+	c.push(&game.Update{Action: game.SWAP, ID: 3})
+	c.push(&game.Update{Action: game.SWAP, ID: 4})
+	c.reply(&game.Ack{Action: game.ACK, RequestID: "b", UpdateID: 4})
+	c.push(&game.Update{Action: game.SWAP, ID: 5})
+	close(puzzle.release)
+
+	if m := received(t, c); m["action"] != float64(game.SNAPSHOT) {
+		t.Fatalf("first message after resync is %v, want a snapshot", m)
+	}
+	for _, requestID := range []string{"a", "b"} {
+		if m := received(t, c); m["action"] != float64(game.ACK) || m["requestID"] != requestID {
+			t.Fatalf("message is %v, want the ack of request %s", m, requestID)
+		}
+	}
+	if m := received(t, c); m["action"] != float64(game.SWAP) || m["id"] != float64(5) {
+		t.Fatalf("message is %v, want the update made after the snapshot", m)
+	}
+	if len(c.send) != 0 {
+		t.Errorf("%d more messages were queued", len(c.send))
+	}
+}
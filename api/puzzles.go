@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -21,6 +22,15 @@ func RegisterPuzzlesRoutes(r *mux.Router) {
 	puzzlesRouter.HandleFunc("/{id}/", CreatePuzzle).Methods("POST")
 	puzzlesRouter.HandleFunc("/{id}/results", GetPuzzleResults).Methods("GET")
 	puzzlesRouter.HandleFunc("/{id}/results/", GetPuzzleResults).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/final", GetPuzzleFinalResults).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/final/", GetPuzzleFinalResults).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/state", GetPuzzleState).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/state/", GetPuzzleState).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/updates", GetPuzzleUpdates).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/updates/", GetPuzzleUpdates).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/timelapse.gif", GetPuzzleTimelapse).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/timelapse", GetPuzzleTimelapseProgress).Methods("GET")
+	puzzlesRouter.HandleFunc("/{id}/timelapse/", GetPuzzleTimelapseProgress).Methods("GET")
 }
 
 // GetPuzzle gets current puzzle's state
@@ -34,9 +44,14 @@ func GetPuzzle(w http.ResponseWriter, r *http.Request) {
 	WriteError(w, 404, map[string]string{"error": "puzzle not found"})
 }
 
-// CreatePuzzle creates a puzzle given a size
+// CreatePuzzle creates a puzzle given a size, and optionally game.Options, and
+// returns the seed it was shuffled with
 func CreatePuzzle(w http.ResponseWriter, r *http.Request) {
-	var userInfo map[string]int
+	var userInfo struct {
+		YSize int `json:"ySize"`
+		XSize int `json:"xSize"`
+		game.Options
+	}
 	id := mux.Vars(r)["id"]
 	err := json.NewDecoder(r.Body).Decode(&userInfo)
 	if err != nil {
@@ -47,22 +62,49 @@ func CreatePuzzle(w http.ResponseWriter, r *http.Request) {
 	pictureFile := "images/" + id + "/original.jpeg"
 	if !fs.DirExists(pictureFile) {
 		WriteError(w, 422, map[string]string{"error": "invalid id provided"})
+		return
 	}
 
-	ySize := userInfo["ySize"]
-	xSize := userInfo["xSize"]
+	ySize := userInfo.YSize
+	xSize := userInfo.XSize
 	if ySize <= 0 || xSize <= 0 || xSize*ySize > 10000 {
 		WriteError(w, 422, map[string]string{"error": "invalid xSize and ySize provided"})
 		return
 	}
-	puzzle := game.NewLivePuzzle(id, pictureFile, ySize, xSize, game.GlobalUserPool)
-	puzzle.Start()
-	game.GlobalPuzzlePool.AddPuzzle(puzzle)
+	if err := userInfo.Options.Validate(); err != nil {
+		WriteError(w, 422, map[string]string{"error": err.Error()})
+		return
+	}
+	if userInfo.Options.Seed == 0 {
+		userInfo.Options.Seed = game.NewSeed()
+	}
+	// reserved before the image is cut, which would overwrite the pieces of
+	// a live puzzle, or of one being created by another request
+	if err := game.GlobalPuzzlePool.Reserve(id); game.CodeOf(err) == game.ErrPuzzleExists {
+		WriteError(w, 409, map[string]string{"error": err.Error()})
+		return
+	} else if err != nil {
+		WriteError(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	defer game.GlobalPuzzlePool.Release(id)
+	var puzzle *game.LivePuzzle
+	if userInfo.Options.Race {
+		puzzle = game.NewRaceLivePuzzle(id, pictureFile, ySize, xSize, userInfo.Options, game.GlobalUserPool)
+	} else {
+		puzzle = game.NewLivePuzzle(id, pictureFile, ySize, xSize, userInfo.Options, game.GlobalUserPool)
+	}
 	if puzzle == nil {
 		WriteError(w, 500, map[string]string{"error": "error creating puzzle"})
 		return
 	}
-	WriteSuccess(w, map[string]string{"id": id})
+	puzzle.Start()
+	if err := game.GlobalPuzzlePool.AddPuzzle(puzzle); err != nil {
+		puzzle.Stop()
+		WriteError(w, 409, map[string]string{"error": err.Error()})
+		return
+	}
+	WriteSuccess(w, map[string]interface{}{"id": id, "seed": userInfo.Options.Seed})
 }
 
 // GetPuzzleResults gets the results of a puzzle
@@ -76,13 +118,167 @@ func GetPuzzleResults(w http.ResponseWriter, r *http.Request) {
 	WriteError(w, 404, map[string]string{"error": "puzzle not found"})
 }
 
+// GetPuzzleFinalResults gets the archived final results of a complete puzzle,
+// so they are still there after the puzzle is pruned
+func GetPuzzleFinalResults(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	final, err := game.GlobalPuzzlePool.FinalResults(id)
+	if err != nil {
+		WriteError(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	if final == nil {
+		WriteError(w, 404, map[string]string{"error": "puzzle not complete"})
+		return
+	}
+	WriteSuccess(w, final)
+}
+
+// GetPuzzleState gets a puzzle's state as it was right after an update, by
+// replaying its log, so finished puzzles can be replayed too
+func GetPuzzleState(w http.ResponseWriter, r *http.Request) {
+	at := -1
+	if r.URL.Query().Get("at") != "" {
+		var err error
+		at, err = strconv.Atoi(r.URL.Query().Get("at"))
+		if err != nil || at < 0 {
+			WriteError(w, 422, map[string]string{"error": "invalid at parameter"})
+			return
+		}
+	}
+
+	events, ok := loggedEvents(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	puzzle, err := game.ReplayPuzzle(events, at)
+	if err != nil {
+		WriteError(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	WriteSuccess(w, puzzle)
+}
+
+// GetPuzzleUpdates gets a page of a puzzle's updates, starting from the
+// update id from, which is 0 by default. The next page starts from next,
+// which is -1 if there are no more updates
+func GetPuzzleUpdates(w http.ResponseWriter, r *http.Request) {
+	from, limit := 0, 100
+	var err error
+	if r.URL.Query().Get("from") != "" {
+		from, err = strconv.Atoi(r.URL.Query().Get("from"))
+		if err != nil || from < 0 {
+			WriteError(w, 422, map[string]string{"error": "invalid from parameter"})
+			return
+		}
+	}
+	if r.URL.Query().Get("limit") != "" {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > 1000 {
+			WriteError(w, 422, map[string]string{"error": "invalid limit parameter"})
+			return
+		}
+	}
+
+	events, ok := loggedEvents(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	updates, more := game.LoggedUpdates(events, from, limit)
+	next := -1
+	if more {
+		next = updates[len(updates)-1].ID + 1
+	}
+	WriteSuccess(w, map[string]interface{}{"updates": updates, "next": next})
+}
+
+// GetPuzzleTimelapse gets an animated gif of a complete puzzle being solved.
+// The first request starts rendering it in the background, and is answered
+// with how far along it is, until it is done
+func GetPuzzleTimelapse(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	file := "images/" + id + "/timelapse.gif"
+	if game.GetTimelapse(id) == nil && fs.DirExists(file) {
+		// rendered before the server restarted
+		http.ServeFile(w, r, file)
+		return
+	}
+
+	puzzle := game.GlobalPuzzlePool.GetPuzzle(id)
+	if puzzle == nil {
+		WriteError(w, 404, map[string]string{"error": "puzzle not found"})
+		return
+	}
+	if !puzzle.Complete() {
+		WriteError(w, 409, map[string]string{"error": "puzzle isn't complete"})
+		return
+	}
+	// timelapses are rendered from the log
+	if _, ok := loggedEvents(w, id); !ok {
+		return
+	}
+	progress := game.StartTimelapse(id, file).Progress()
+	if progress.Error != "" {
+		WriteError(w, 500, progress)
+		return
+	}
+	if progress.Done {
+		http.ServeFile(w, r, file)
+		return
+	}
+	WriteAccepted(w, progress)
+}
+
+// loggedEvents returns the log of a puzzle, or writes why it can't be read.
+// Puzzles that are live but have no log, like races, which aren't logged, are
+// answered with a 501
+func loggedEvents(w http.ResponseWriter, id string) ([]*game.Event, bool) {
+	events, err := game.GlobalPuzzlePool.Events(id)
+	if err != nil {
+		WriteError(w, 500, map[string]string{"error": err.Error()})
+		return nil, false
+	}
+	if len(events) > 0 {
+		return events, true
+	}
+	if game.GlobalPuzzlePool.GetPuzzle(id) != nil {
+		WriteError(w, 501, map[string]string{"error": "puzzle isn't logged"})
+	} else {
+		WriteError(w, 404, map[string]string{"error": "puzzle not found"})
+	}
+	return nil, false
+}
+
+// GetPuzzleTimelapseProgress gets how far along rendering a puzzle's timelapse
+// is
+func GetPuzzleTimelapseProgress(w http.ResponseWriter, r *http.Request) {
+	job := game.GetTimelapse(mux.Vars(r)["id"])
+	if job == nil {
+		WriteError(w, 404, map[string]string{"error": "timelapse not started"})
+		return
+	}
+	WriteSuccess(w, job.Progress())
+}
+
 // UpgradePuzzle creates puzzle socket
 func UpgradePuzzle(w http.ResponseWriter, r *http.Request) {
+	// spectators watch the puzzle without joining it, and connect without a
+	// user, or with the spectator role
 	userID := r.URL.Query().Get("user")
-	if userID == "" {
-		log.Println("user id")
-		WriteError(w, 404, map[string]string{"error": "user parameter not supplied"})
-		return
+	spectator := userID == "" || r.URL.Query().Get("role") == "spectator"
+	// team is the team the user joins, if any
+	team := r.URL.Query().Get("team")
+
+	// since is the last update id the client has seen, if it is resuming
+	since := -1
+	resume := r.URL.Query().Get("since") != ""
+	if resume {
+		var err error
+		since, err = strconv.Atoi(r.URL.Query().Get("since"))
+		if err != nil {
+			WriteError(w, 422, map[string]string{"error": "invalid since parameter"})
+			return
+		}
 	}
 
 	id := mux.Vars(r)["id"]
@@ -100,35 +296,93 @@ func UpgradePuzzle(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, 500, map[string]string{"error": "error upgrading websocket"})
 		return
 	}
-	go setupConnection(conn, puzzle, userID)
+	if spectator {
+		go setupSpectator(conn, puzzle, resume, since)
+		return
+	}
+	go setupConnection(conn, puzzle, userID, team, resume, since)
 }
 
 // setupConnection connects all the pipelines and channels together
-func setupConnection(c *websocket.Conn, p game.LivePuzzleBase, userID string) {
-	defer c.Close()
+// if resume is set, every update after since is replayed to the client first
+func setupConnection(
+	c *websocket.Conn,
+	p game.LivePuzzleBase,
+	userID string,
+	team string,
+	resume bool,
+	since int) {
+	conn := newConnection(c, p)
+	defer conn.close()
+	go conn.writePump()
 
 	// pushing updates path
-	p.AddCallback(func(u *game.Update) {
-		serializedUpdate, err := json.Marshal(u)
+	// the subscription is closed along with the connection
+	subscribe(conn, p, resume, since)
+
+	// users that aren't in the pool, like the players of restored puzzles,
+	// are loaded here, since the puzzle doesn't wait on the store
+	game.GlobalUserPool.LoadUser(userID)
+	// wire up connections first, then send join message, so we also get connected message
+	if err := p.Connect(userID, team); err != nil {
+		conn.reject(err)
+		return
+	}
+	for {
+		msg, err := conn.read()
 		if err != nil {
+			log.Println(err)
+			p.Disconnect(userID)
 			return
 		}
-		c.WriteMessage(websocket.TextMessage, serializedUpdate)
-	})
+		var r game.Request
+		if err := json.Unmarshal(msg, &r); err != nil {
+			continue
+		}
+		// requests are always made as the user that connected, whoever the
+		// client claims to be
+		r.UserID = userID
+		r.OnReply = conn.reply
+		p.AddRequest(&r)
+	}
+}
 
-	p.AddRequest(&game.Request{Action: game.JOIN, UserID: userID})
-	// wire up connections first, then send join message, so we also get connected message
+// setupSpectator connects a spectator, who is sent every update without
+// joining the puzzle. Requests from spectators are rejected without reaching
+// the puzzle
+func setupSpectator(
+	c *websocket.Conn,
+	p game.LivePuzzleBase,
+	resume bool,
+	since int) {
+	conn := newConnection(c, p)
+	defer conn.close()
+	go conn.writePump()
+
+	p.Spectate(subscribe(conn, p, resume, since))
 	for {
-		msgType, msg, err := c.ReadMessage()
-		if err != nil || msgType != websocket.TextMessage {
+		msg, err := conn.read()
+		if err != nil {
 			log.Println(err)
-			p.AddRequest(&game.Request{Action: game.LEAVE, UserID: userID})
 			return
 		}
 		var r game.Request
 		if err := json.Unmarshal(msg, &r); err != nil {
 			continue
 		}
-		p.AddRequest(&r)
+		if r.RequestID != "" {
+			conn.reply(game.NewNack(r.RequestID, game.ErrSpectating, "spectators can't send requests"))
+		}
+	}
+}
+
+// subscribe subscribes a connection to the updates of a puzzle, replaying
+// every update after since first if resume is set. The subscription is closed
+// along with the connection
+func subscribe(conn *connection, p game.LivePuzzleBase, resume bool, since int) *game.Subscription {
+	if resume {
+		s, _ := p.SubscribeSince(conn.ctx, since, conn.push)
+		return s
 	}
+	return p.Subscribe(conn.ctx, conn.push)
 }
@@ -16,6 +16,8 @@ func RegisterUsersRoutes(r *mux.Router) {
 	usersRouter.HandleFunc("/", CreateUser).Methods("POST")
 	usersRouter.HandleFunc("/{id}", GetUser).Methods("GET")
 	usersRouter.HandleFunc("/{id}/", GetUser).Methods("GET")
+	usersRouter.HandleFunc("/{id}/results", GetUserResults).Methods("GET")
+	usersRouter.HandleFunc("/{id}/results/", GetUserResults).Methods("GET")
 	usersRouter.HandleFunc("/auth", AuthUser).Methods("GET")
 	usersRouter.HandleFunc("/auth/", AuthUser).Methods("GET")
 }
@@ -23,13 +25,24 @@ func RegisterUsersRoutes(r *mux.Router) {
 // GetUser gets a user given an id
 func GetUser(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
-	if user := game.GlobalUserPool.GetUser(id); user != nil {
+	if user := game.GlobalUserPool.LoadUser(id); user != nil {
 		WriteSuccess(w, user)
 		return
 	}
 	WriteError(w, 404, map[string]string{"error": "player not found"})
 }
 
+// GetUserResults gets how a user placed in every puzzle they completed
+func GetUserResults(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	results, err := game.GlobalPuzzlePool.UserResults(id)
+	if err != nil {
+		WriteError(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	WriteSuccess(w, results)
+}
+
 // AuthUser returns the uuid of a user given a username and password
 func AuthUser(w http.ResponseWriter, r *http.Request) {
 	user, password, ok := r.BasicAuth()
@@ -17,3 +17,11 @@ func WriteSuccess(w http.ResponseWriter, body interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(body)
 }
+
+// WriteAccepted writes a response to a request that is still being processed
+// to ResponseWriter
+func WriteAccepted(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(202)
+	json.NewEncoder(w).Encode(body)
+}
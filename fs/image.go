@@ -2,7 +2,9 @@ package fs
 
 import (
 	"image"
+	"image/gif"
 	"image/jpeg"
+	"image/png"
 	"os"
 )
 
@@ -29,3 +31,23 @@ func SaveImage(filename string, img image.Image) error {
 	defer f.Close()
 	return jpeg.Encode(f, img, nil)
 }
+
+// SavePNG saves an image to the file system as a png, keeping transparency
+func SavePNG(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
+
+// SaveGIF saves an animated gif to the file system with the filename
+func SaveGIF(filename string, anim *gif.GIF) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gif.EncodeAll(f, anim)
+}
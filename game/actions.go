@@ -1,5 +1,10 @@
 package game
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type action int
 
 // actions that could be performed
@@ -8,26 +13,152 @@ const (
 	HOLD
 	JOIN
 	LEAVE
+	SNAPSHOT
+	ACK
+	NACK
+	MOVE
+	DROP
+	MERGE
+	BLOCK
+	ROTATE
+	FINISH
+	START
+	TICK
+	PAUSE
+	RESUME
+	EXPIRE
+	COMPLETE
+	UNLOCK
+	HINT
+	UNDO
+	BATCH
 )
 
 // Request representing a request to move something
+// if RequestID is set, OnReply is called with an Ack once the request is done
+// in free mode, boardPos is where a MOVE or DROP puts the piece at position
+// a JOIN with a team puts the user on that team, users stay on the first team
+// they join
+// a BATCH moves the pieces selected in pieces as a block, so the first one lands
+// on position
+// time is when the server received the request
+// internal requests are made by the server, and can't be sent by clients.
+// JOIN, LEAVE and TICK are always internal
 type Request struct {
-	Action   action   `json:"action"`
-	UserID   string   `json:"userID"`
-	PiecePos Position `json:"position"`
+	Action    action     `json:"action"`
+	UserID    string     `json:"userID"`
+	PiecePos  Position   `json:"position"`
+	BoardPos  Point      `json:"boardPos"`
+	RequestID string     `json:"requestID,omitempty"`
+	Team      string     `json:"team,omitempty"`
+	Pieces    []Position `json:"pieces,omitempty"`
+	Time      time.Time  `json:"time"`
+	OnReply   func(*Ack) `json:"-"`
+	internal  bool
 }
 
 // Update representing a state change of the puzzle
-// delta represents change in number of correct pieces
+// delta represents change in number of correct pieces, and points how many
+// points the user that caused it scored, according to the puzzle's scoring
 // - if Action is a SWAP, piece1ID and piece2ID are populated
 //   * swap is implicitly a RELEASE state change if piece1ID == piece2
 // - if Action is a HOLD, piece1ID and userID are populated
 // - if Action is a JOIN or LEAVE, only userID is populated
+// - if Action is a MOVE or DROP, piece1Pos and boardPos are populated. HOLD
+//   updates in free mode also have boardPos populated
+// - if Action is a BLOCK, a held group was moved, and moves lists every piece
+//   that moved, including the ones that were in the way
+// - if Action is a MERGE, groups were joined, and pieces lists every piece in
+//   the resulting group
+// - if Action is a ROTATE, piece1Pos and rotation are populated
+// pieces is also populated with the whole group for updates about a piece in a
+// group with other pieces
+// - if Action is a FINISH, a race is over, board is the board that won, or
+//   empty if it was a draw, and scores has how many pieces each board got
+//   correct
+// - if Action is a START, PAUSE, RESUME, TICK or EXPIRE, clock is populated
+//   with the puzzle's clock. START is sent when the first user joins, PAUSE and
+//   RESUME when a user pauses or resumes the puzzle, TICK every 10 seconds
+//   while the clock of a timed puzzle runs, and EXPIRE when it runs out
+// - if Action is a COMPLETE, the puzzle is complete, clock is populated with
+//   its stopped clock, and final with its final results. A FINISH of a race
+//   also has final populated
+// - if Action is an UNLOCK, the host unlocked the piece at piece1Pos, along
+//   with its group
+// - if Action is a HINT, piece1Pos is the piece the user asked about, and
+//   piece2Pos is where it belongs. points is what the hint cost, and
+//   hintsLeft how many hints the user has left, where a missing hintsLeft is 0.
+//   Hints are sent to everyone, so teammates can see them
+// - if Action is a BATCH, a user moved the pieces they selected as a block, the
+//   first of them from piece1Pos to piece2Pos, and moves lists every piece that
+//   moved, including the ones that were in the way
+// - if Action is an UNDO, the user's last swap was swapped back, like a SWAP.
+//   delta and points take back what the swap changed
+// locked is populated with the pieces an update locked, when correct pieces
+// are locked
+// updates caused by a user on a team have team and teamScore populated, the
+// team's score after the update, where a missing teamScore is 0
+// in a race, board is populated with the board an update happened on
+// - if Action is a SNAPSHOT, only puzzle is populated, and the id is -1. Updates
+//   with an id lower than the puzzle's nextUpdateID are already applied to it
+// requestID is populated with the id of the request that caused the update
 type Update struct {
-	ID        int      `json:"id"`
-	Action    action   `json:"action"`
-	UserID    string   `json:"userID"`
-	Piece1Pos Position `json:"piece1Pos"`
-	Piece2Pos Position `json:"piece2Pos"`
-	Delta     int      `json:"delta"`
+	ID        int             `json:"id"`
+	Action    action          `json:"action"`
+	UserID    string          `json:"userID"`
+	Piece1Pos Position        `json:"piece1Pos"`
+	Piece2Pos Position        `json:"piece2Pos"`
+	Delta     int             `json:"delta"`
+	Points    int             `json:"points,omitempty"`
+	BoardPos  *Point          `json:"boardPos,omitempty"`
+	Pieces    []Position      `json:"pieces,omitempty"`
+	Moves     []Move          `json:"moves,omitempty"`
+	Rotation  int             `json:"rotation,omitempty"`
+	Team      string          `json:"team,omitempty"`
+	TeamScore int             `json:"teamScore,omitempty"`
+	Board     string          `json:"board,omitempty"`
+	Scores    map[string]int  `json:"scores,omitempty"`
+	Clock     *Clock          `json:"clock,omitempty"`
+	Final     *FinalResults   `json:"final,omitempty"`
+	Locked    []Position      `json:"locked,omitempty"`
+	HintsLeft int             `json:"hintsLeft,omitempty"`
+	Puzzle    json.RawMessage `json:"puzzle,omitempty"`
+	RequestID string          `json:"requestID,omitempty"`
+}
+
+// Ack acknowledges a request that was sent with a request id
+// - if Action is an ACK, updateID is the id of the last update the request
+//   caused. The ack can arrive before that update does
+// - if Action is a NACK, updateID is -1, and code and error say why the
+//   request was rejected
+type Ack struct {
+	Action    action    `json:"action"`
+	RequestID string    `json:"requestID"`
+	UpdateID  int       `json:"updateID"`
+	Code      ErrorCode `json:"code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// newAck creates the ack for a request given the updates it caused, or the
+// error it was rejected with
+func newAck(requestID string, updates []*Update, err error) *Ack {
+	if err != nil {
+		return &Ack{
+			Action:    NACK,
+			RequestID: requestID,
+			UpdateID:  -1,
+			Code:      CodeOf(err),
+			Error:     err.Error()}
+	}
+	updateID := -1
+	if len(updates) > 0 {
+		updateID = updates[len(updates)-1].ID
+	}
+	return &Ack{Action: ACK, RequestID: requestID, UpdateID: updateID}
+}
+
+// NewNack creates the ack for a request that was rejected before it reached
+// a puzzle
+func NewNack(requestID string, code ErrorCode, message string) *Ack {
+	return newAck(requestID, nil, newError(code, message))
 }
@@ -0,0 +1,76 @@
+package game
+
+import "time"
+
+// batch moves the pieces a user selected as a block, so that the first
+// selected piece lands on the request's position. Pieces in the way are moved
+// into the cells the block left, like when a group is moved. When groups are
+// on, the whole group of every selected piece is moved along with it
+func (p *Puzzle) batch(r Request) error {
+	if p.Mode == FreeMode {
+		return newError(ErrWrongMode, "pieces can only be moved in batches in grid mode")
+	}
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+	if p.HeldPieces[r.UserID] != nil {
+		return newError(ErrAlreadyHolding, "user is already holding a piece")
+	}
+	if len(r.Pieces) == 0 {
+		return newError(ErrBadSelection, "no pieces are selected")
+	}
+	if !p.inBounds(r.PiecePos) {
+		return newError(ErrOutOfBounds, "piece x and y out of bounds")
+	}
+
+	selected := make(map[*Piece]bool)
+	block := make([]*Piece, 0, len(r.Pieces))
+	for _, pos := range r.Pieces {
+		if !p.inBounds(pos) {
+			return newError(ErrOutOfBounds, "piece x and y out of bounds")
+		}
+		piece := p.Pieces[pos.Y][pos.X]
+		if selected[piece] {
+			return newError(ErrBadSelection, "piece is selected more than once")
+		}
+		for _, member := range p.group(piece) {
+			if selected[member] {
+				continue
+			}
+			if member.HeldBy != "" {
+				return newError(ErrPieceHeld, "piece is held by a user")
+			}
+			if member.Locked {
+				return newError(ErrPieceLocked, "piece is locked")
+			}
+			selected[member] = true
+			block = append(block, member)
+		}
+	}
+
+	first := r.Pieces[0]
+	if !p.topology.SameShape(first, r.PiecePos) {
+		return newError(ErrWrongShape, "pieces can't be moved to cells of a different shape")
+	}
+	offset := Position{X: r.PiecePos.X - first.X, Y: r.PiecePos.Y - first.Y}
+	delta, moves, err := p.moveBlock(block, offset, r.UserID)
+	if err != nil {
+		return err
+	}
+	p.LastUpdated = time.Now()
+
+	affected := make([]*Piece, 0, len(moves))
+	for _, m := range moves {
+		affected = append(affected, p.Pieces[m.To.Y][m.To.X])
+	}
+	points := p.score(r.UserID, delta, affected)
+	sizes := p.groupSizes(affected)
+	p.regroup()
+
+	update := p.newUpdate(BATCH, r.UserID, first, r.PiecePos, delta)
+	update.Points = points
+	update.Moves = moves
+	p.emit(update)
+	p.emitMerges(r.UserID, sizes)
+	return nil
+}
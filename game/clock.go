@@ -0,0 +1,141 @@
+package game
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// tickInterval is how often live puzzles check their clock
+	tickInterval = time.Second
+	// tickUpdateInterval is how often the clock of a timed puzzle is sent out
+	// while it runs, in seconds
+	tickUpdateInterval = 10
+)
+
+// ClockState is what the clock of a puzzle is doing
+type ClockState string
+
+// states the clock of a puzzle can be in
+const (
+	// ClockWaiting is the state of a clock until the first user joins
+	ClockWaiting ClockState = "waiting"
+	ClockRunning ClockState = "running"
+	ClockPaused  ClockState = "paused"
+	// ClockExpired is the state of a clock that ran out of time
+	ClockExpired ClockState = "expired"
+	// ClockStopped is the state of a clock once the puzzle is complete
+	ClockStopped ClockState = "stopped"
+)
+
+// Clock is the game clock of a puzzle. Elapsed is how many seconds it ran for
+// as of At, and it keeps counting from there while it is running. Puzzles with
+// a time limit expire once the clock reaches it
+type Clock struct {
+	State     ClockState `json:"state"`
+	TimeLimit int        `json:"timeLimit,omitempty"`
+	Elapsed   float64    `json:"elapsed"`
+	At        time.Time  `json:"at"`
+}
+
+// elapsed returns how many seconds the clock ran for as of now
+func (c *Clock) elapsed(now time.Time) float64 {
+	if c.State != ClockRunning {
+		return c.Elapsed
+	}
+	return c.Elapsed + now.Sub(c.At).Seconds()
+}
+
+// set brings the clock up to date as of now, and puts it in a new state
+func (c *Clock) set(state ClockState, now time.Time) {
+	c.Elapsed = c.elapsed(now)
+	c.At = now
+	c.State = state
+}
+
+// requestTime returns when a request was received, or the current time if it
+// wasn't stamped. Times don't have a monotonic reading, so they are the same
+// after being logged and replayed
+func requestTime(r Request) time.Time {
+	if r.Time.IsZero() {
+		return time.Now().Round(0)
+	}
+	return r.Time.Round(0)
+}
+
+// checkClock returns an error if pieces can't be moved because the clock is
+// paused or expired
+func (p *Puzzle) checkClock() error {
+	switch p.Clock.State {
+	case ClockPaused:
+		return newError(ErrPaused, "puzzle is paused")
+	case ClockExpired:
+		return newError(ErrTimeUp, "puzzle ran out of time")
+	}
+	return nil
+}
+
+// startClock starts the clock if it is waiting for the first user to join
+func (p *Puzzle) startClock(userID string) {
+	if p.Clock.State == ClockWaiting {
+		p.Clock.set(ClockRunning, p.requestTime)
+		p.emit(p.newClockUpdate(START, userID))
+	}
+}
+
+// pause pauses the clock, until a user resumes it
+func (p *Puzzle) pause(r Request) error {
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+	if err := p.checkClock(); err != nil {
+		return err
+	}
+
+	p.Clock.set(ClockPaused, p.requestTime)
+	p.emit(p.newClockUpdate(PAUSE, r.UserID))
+	return nil
+}
+
+// resume restarts a paused clock
+func (p *Puzzle) resume(r Request) error {
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+	if p.Clock.State != ClockPaused {
+		return newError(ErrNotPaused, "puzzle isn't paused")
+	}
+
+	p.Clock.set(ClockRunning, p.requestTime)
+	p.emit(p.newClockUpdate(RESUME, r.UserID))
+	return nil
+}
+
+// tick expires the clock once it reaches the time limit, and sends it out
+// every tickUpdateInterval seconds while it runs
+func (p *Puzzle) tick(r Request) error {
+	if !r.internal {
+		return newError(ErrUnknownAction, "unknown action")
+	}
+	if p.Clock.State != ClockRunning || p.Clock.TimeLimit == 0 {
+		return nil
+	}
+
+	elapsed := p.Clock.elapsed(p.requestTime)
+	if elapsed >= float64(p.Clock.TimeLimit) {
+		p.Clock.set(ClockExpired, p.requestTime)
+		p.emit(p.newClockUpdate(EXPIRE, ""))
+	} else if math.Floor(elapsed/tickUpdateInterval) > math.Floor(p.Clock.Elapsed/tickUpdateInterval) {
+		p.Clock.set(ClockRunning, p.requestTime)
+		p.emit(p.newClockUpdate(TICK, ""))
+	}
+	return nil
+}
+
+// newClockUpdate creates an update with the current state of the clock
+func (p *Puzzle) newClockUpdate(action action, userID string) *Update {
+	update := p.newUpdate(action, userID, Position{}, Position{}, 0)
+	clock := *p.Clock
+	update.Clock = &clock
+	return update
+}
@@ -0,0 +1,61 @@
+package game
+
+// ErrorCode is a stable, machine readable reason for a request being rejected
+type ErrorCode string
+
+// codes for rejected requests
+const (
+	ErrInternal       ErrorCode = "INTERNAL"
+	ErrUnknownAction  ErrorCode = "UNKNOWN_ACTION"
+	ErrPuzzleComplete ErrorCode = "PUZZLE_COMPLETE"
+	ErrOutOfBounds    ErrorCode = "OUT_OF_BOUNDS"
+	ErrNotJoined      ErrorCode = "NOT_JOINED"
+	ErrAlreadyJoined  ErrorCode = "ALREADY_JOINED"
+	ErrUnknownUser    ErrorCode = "UNKNOWN_USER"
+	ErrPieceHeld      ErrorCode = "PIECE_HELD"
+	ErrInvalidOptions ErrorCode = "INVALID_OPTIONS"
+	ErrWrongMode      ErrorCode = "WRONG_MODE"
+	ErrNotHolding     ErrorCode = "NOT_HOLDING"
+	ErrAlreadyHolding ErrorCode = "ALREADY_HOLDING"
+	ErrInGroup        ErrorCode = "IN_GROUP"
+	ErrInvalidTeam    ErrorCode = "INVALID_TEAM"
+	ErrPaused         ErrorCode = "PAUSED"
+	ErrNotPaused      ErrorCode = "NOT_PAUSED"
+	ErrTimeUp         ErrorCode = "TIME_UP"
+	ErrPieceLocked    ErrorCode = "PIECE_LOCKED"
+	ErrNotLocked      ErrorCode = "NOT_LOCKED"
+	ErrNotHost        ErrorCode = "NOT_HOST"
+	ErrNoHints        ErrorCode = "NO_HINTS"
+	ErrAlreadyCorrect ErrorCode = "ALREADY_CORRECT"
+	ErrNothingToUndo  ErrorCode = "NOTHING_TO_UNDO"
+	ErrCantUndo       ErrorCode = "CANT_UNDO"
+	ErrBadSelection   ErrorCode = "BAD_SELECTION"
+	ErrWrongShape     ErrorCode = "WRONG_SHAPE"
+	ErrSpectating     ErrorCode = "SPECTATING"
+	ErrPuzzleExists   ErrorCode = "PUZZLE_EXISTS"
+)
+
+// Error is the error returned for a rejected request
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// newError creates an error with a code
+func newError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Error returns the error message
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// CodeOf returns the code of an error returned by a puzzle, or ErrInternal if
+// the error doesn't have one
+func CodeOf(err error) ErrorCode {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return ErrInternal
+}
@@ -0,0 +1,313 @@
+package game
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ilikerice123/puzzle/store"
+)
+
+// Event is an entry in the append-only log of a puzzle
+// - the first event of a log has genesis populated with the state the puzzle
+//   started in, after it was shuffled
+// - if restored is set, the server restarted, and the puzzle was restored
+//   with nobody playing it, and clock populated with its restored clock
+// - otherwise, request is a request the puzzle did, and updates are the
+//   updates it caused. internal is set if the request was made by the
+//   server
+type Event struct {
+	Time     time.Time    `json:"time"`
+	Genesis  *PuzzleState `json:"genesis,omitempty"`
+	Restored bool         `json:"restored,omitempty"`
+	Clock    *Clock       `json:"clock,omitempty"`
+	Request  *Request     `json:"request,omitempty"`
+	Internal bool         `json:"internal,omitempty"`
+	Updates  []*Update    `json:"updates,omitempty"`
+}
+
+// EventStore stores the append-only logs of puzzles. Appended events may only
+// be flushed to disk once the log is synced or closed
+type EventStore interface {
+	Append(id string, e *Event) error
+
+	Events(id string) ([]*Event, error)
+
+	Sync(id string) error
+
+	Close(id string) error
+}
+
+// FileEventStore implements EventStore by saving the log of each puzzle as a
+// file of json lines in a directory. Logs are kept open while they are
+// appended to
+type FileEventStore struct {
+	dir string
+	// lock guards logs, the open logs by puzzle id
+	lock sync.Mutex
+	logs map[string]*eventFile
+}
+
+// eventFile is an open log, buffering the events appended to it
+type eventFile struct {
+	file   *os.File
+	writer *bufio.Writer
+	// unsynced is set once events were appended since the log was synced
+	unsynced bool
+}
+
+// NewFileEventStore creates an event store in dir, creating it if necessary
+func NewFileEventStore(dir string) (*FileEventStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileEventStore{dir: dir, logs: make(map[string]*eventFile)}, nil
+}
+
+// Append appends an event to the log of a puzzle, opening it if necessary
+func (s *FileEventStore) Append(id string, e *Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	open, exists := s.logs[id]
+	if !exists {
+		f, err := os.OpenFile(s.path(id), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		open = &eventFile{file: f, writer: bufio.NewWriter(f)}
+		s.logs[id] = open
+	}
+	open.unsynced = true
+	return json.NewEncoder(open.writer).Encode(e)
+}
+
+// Sync flushes the events appended to the log of a puzzle to disk
+func (s *FileEventStore) Sync(id string) error {
+	s.lock.Lock()
+	open, exists := s.logs[id]
+	if !exists || !open.unsynced {
+		s.lock.Unlock()
+		return nil
+	}
+	open.unsynced = false
+	err := open.writer.Flush()
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	// other logs can be appended to while the file is synced
+	err = open.file.Sync()
+	if errors.Is(err, os.ErrClosed) {
+		// the log was closed, which synced it
+		return nil
+	}
+	return err
+}
+
+// Close flushes the log of a puzzle to disk, and closes it until it is
+// appended to again
+func (s *FileEventStore) Close(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	open, exists := s.logs[id]
+	if !exists {
+		return nil
+	}
+	delete(s.logs, id)
+	err := open.writer.Flush()
+	if err == nil {
+		err = open.file.Sync()
+	}
+	if closeErr := open.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Events returns every event in the log of a puzzle, or none if it doesn't
+// have a log
+func (s *FileEventStore) Events(id string) ([]*Event, error) {
+	s.lock.Lock()
+	if open, exists := s.logs[id]; exists {
+		// events that weren't synced yet are read back too
+		if err := open.writer.Flush(); err != nil {
+			s.lock.Unlock()
+			return nil, err
+		}
+	}
+	s.lock.Unlock()
+
+	events := make([]*Event, 0)
+	err := readJSONLines(s.path(id), func(line []byte) error {
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		events = append(events, &e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// path returns the file the log of a puzzle is saved in
+func (s *FileEventStore) path(id string) string {
+	return filepath.Join(s.dir, id+".log")
+}
+
+// appendJSONLine appends v to a file of json lines, creating it if necessary,
+// and flushes it to disk
+func appendJSONLine(file string, v interface{}) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readJSONLines calls decode with every line of a file of json lines, until
+// decode fails. A file that doesn't exist has no lines
+func readJSONLines(file string, decode func(line []byte) error) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if err := decode(scanner.Bytes()); err != nil {
+			// a crash can leave the last line partially written
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// ReplayPuzzle rebuilds a puzzle by doing every request in its log again,
+// starting from its genesis, until the request that caused update until, or
+// the end of the log if until is negative. The rebuilt puzzle doesn't send
+// updates
+func ReplayPuzzle(events []*Event, until int) (*Puzzle, error) {
+	var puzzle *Puzzle
+	err := replay(events, func(p *Puzzle) bool {
+		puzzle = p
+		return until < 0 || p.NextUpdateID <= until
+	})
+	if err != nil {
+		return nil, err
+	}
+	return puzzle, nil
+}
+
+// replay rebuilds a puzzle from its log, calling step with it after its genesis
+// and after every event, until step returns false
+func replay(events []*Event, step func(*Puzzle) bool) error {
+	if len(events) == 0 || events[0].Genesis == nil {
+		return fmt.Errorf("log doesn't start with a genesis")
+	}
+	puzzle := RestorePuzzle(events[0].Genesis, nil, newReplayUserPool())
+	if !step(puzzle) {
+		return nil
+	}
+	for _, e := range events[1:] {
+		if e.Restored {
+			puzzle.disconnectAll()
+			if e.Clock != nil {
+				clock := *e.Clock
+				puzzle.Clock = &clock
+			}
+		} else if e.Request != nil {
+			if len(e.Updates) > 0 && e.Updates[0].ID != puzzle.NextUpdateID {
+				return fmt.Errorf("log skips from update %d to %d", puzzle.NextUpdateID, e.Updates[0].ID)
+			}
+			req := *e.Request
+			// joins and leaves were always made by the server, even when
+			// they were logged before being marked internal
+			req.internal = e.Internal || req.Action == JOIN || req.Action == LEAVE
+			updates, err := puzzle.Do(req)
+			if err != nil {
+				return fmt.Errorf("replaying update %d: %s", puzzle.NextUpdateID, err.Error())
+			}
+			if len(updates) != len(e.Updates) {
+				return fmt.Errorf("replaying update %d caused different updates", e.Updates[0].ID)
+			}
+		}
+		if !step(puzzle) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// LoggedUpdates returns at most limit updates from a log, starting from update
+// from, and whether there are more updates after them
+func LoggedUpdates(events []*Event, from int, limit int) ([]*Update, bool) {
+	updates := make([]*Update, 0)
+	for _, e := range events {
+		for _, u := range e.Updates {
+			if u.ID < from {
+				continue
+			}
+			if len(updates) == limit {
+				return updates, true
+			}
+			updates = append(updates, u)
+		}
+	}
+	return updates, false
+}
+
+// replayUserPool is the user pool of a replayed puzzle, where every user
+// exists, and starts without any pieces. Users are named after the users in
+// GlobalUserPool, if they are there
+type replayUserPool struct {
+	users map[string]*store.User
+}
+
+// newReplayUserPool creates a pool of users for replaying
+func newReplayUserPool() *replayUserPool {
+	return &replayUserPool{users: make(map[string]*store.User)}
+}
+
+// AddUser adds a user to the pool
+func (p *replayUserPool) AddUser(u *store.User) {
+	p.users[u.ID] = u
+}
+
+// GetUser gets a user from the pool, creating it if it doesn't exist
+func (p *replayUserPool) GetUser(id string) *store.User {
+	if _, exists := p.users[id]; !exists {
+		p.users[id] = &store.User{ID: id, PieceCount: make(map[string]int)}
+		if GlobalUserPool != nil {
+			if u := GlobalUserPool.GetUser(id); u != nil {
+				p.users[id].Name = u.Name
+				p.users[id].Created = u.Created
+			}
+		}
+	}
+	return p.users[id]
+}
+
+// LoadUser gets a user from the pool like GetUser
+func (p *replayUserPool) LoadUser(id string) *store.User {
+	return p.GetUser(id)
+}
+
+// Prune does nothing, since replays are short lived
+func (p *replayUserPool) Prune() {
+}
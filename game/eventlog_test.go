@@ -0,0 +1,180 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// savedState saves a live puzzle to a new store, and loads its state back
+func savedState(t *testing.T, p *LivePuzzle) *PuzzleState {
+	t.Helper()
+	puzzles, err := NewFilePuzzleStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(puzzles); err != nil {
+		t.Fatal(err)
+	}
+	states, err := puzzles.LoadPuzzles()
+	if err != nil || len(states) != 1 {
+		t.Fatalf("loaded %d puzzles: %v", len(states), err)
+	}
+	return states[0]
+}
+
+// sameState fails the test if two states differ, besides when they were last
+// updated
+func sameState(t *testing.T, live *PuzzleState, replayed *PuzzleState) {
+	t.Helper()
+	live.LastUpdated, replayed.LastUpdated = time.Time{}, time.Time{}
+	liveJSON, _ := json.Marshal(live)
+	replayedJSON, _ := json.Marshal(replayed)
+	if string(liveJSON) != string(replayedJSON) {
+		t.Errorf("replayed state differs from the live one\nlive:     %s\nreplayed: %s", liveJSON, replayedJSON)
+	}
+}
+
+func TestReplayMatchesLivePuzzle(t *testing.T) {
+	users := newTestUsers("u1", "u2", "u3")
+	events, err := NewFileEventStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := Options{
+		Groups:      true,
+		LockCorrect: true,
+		Host:        "u1",
+		Hints:       2,
+		HintCost:    1,
+		Scoring:     &Ruleset{Placement: 2, Penalty: 1, Streak: 1}}
+	shuffled := newTestPuzzle(3, 3, options, users)
+	shuffled.Shuffle()
+	shuffled.regroup()
+
+	p := RestoreLivePuzzle(shuffled.State(), users)
+	p.Start()
+	if err := p.Record(events, false); err != nil {
+		t.Fatal(err)
+	}
+	connect(t, p, "u1", "")
+	connect(t, p, "u2", "red")
+	connect(t, p, "u3", "red")
+	requests := []*Request{
+		{Action: HINT, UserID: "u2", PiecePos: Position{Y: 1, X: 1}},
+	}
+	for i := 0; i < 30; i++ {
+		userID := []string{"u1", "u2", "u3"}[i%3]
+		requests = append(requests,
+			&Request{Action: HOLD, UserID: userID, PiecePos: Position{Y: i % 3, X: i / 3 % 3}},
+			&Request{Action: HOLD, UserID: userID, PiecePos: Position{Y: i / 2 % 3, X: i % 3}})
+	}
+	requests = append(requests, &Request{Action: UNDO, UserID: "u3"})
+	for _, r := range requests {
+		p.AddRequest(r)
+	}
+	p.Disconnect("u2")
+	// the server restarts, and the puzzle is restored from where it was saved
+	state := savedState(t, p)
+	p.Stop()
+	p = RestoreLivePuzzle(state, users)
+	p.Start()
+	defer p.Stop()
+	if err := p.Record(events, true); err != nil {
+		t.Fatal(err)
+	}
+	connect(t, p, "u3", "")
+	requests = []*Request{
+		{Action: HOLD, UserID: "u3", PiecePos: Position{Y: 2, X: 2}},
+		{Action: HOLD, UserID: "u3", PiecePos: Position{Y: 0, X: 0}},
+		{Action: PAUSE, UserID: "u3"},
+	}
+	for _, r := range requests {
+		p.AddRequest(r)
+	}
+	live := savedState(t, p)
+
+	logged, err := events.Events("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := ReplayPuzzle(logged, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live.NextUpdateID < 10 {
+		t.Fatalf("only %d updates were made", live.NextUpdateID)
+	}
+	sameState(t, live, replayed.State())
+}
+
+func TestReplayUntil(t *testing.T) {
+	users := newTestUsers("u1")
+	events, err := NewFileEventStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	shuffled := newTestPuzzle(2, 2, Options{}, users)
+	shuffled.Shuffle()
+	p := RestoreLivePuzzle(shuffled.State(), users)
+	p.Start()
+	defer p.Stop()
+	if err := p.Record(events, false); err != nil {
+		t.Fatal(err)
+	}
+	// the JOIN causes a JOIN and a START, and each HOLD a HOLD or a SWAP
+	connect(t, p, "u1", "")
+	p.AddRequest(&Request{Action: HOLD, UserID: "u1", PiecePos: Position{Y: 0, X: 0}})
+	p.AddRequest(&Request{Action: HOLD, UserID: "u1", PiecePos: Position{Y: 0, X: 1}})
+	savedState(t, p)
+
+	logged, err := events.Events("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for until, want := range []int{2, 2, 3, 4} {
+		replayed, err := ReplayPuzzle(logged, until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replayed.NextUpdateID != want {
+			t.Errorf("replaying until update %d made %d updates, want %d", until, replayed.NextUpdateID, want)
+		}
+	}
+	genesis, err := ReplayPuzzle(logged[:1], -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameState(t, shuffled.State(), genesis.State())
+}
+
+func TestFileEventStoreKeepsLogOpen(t *testing.T) {
+	events, err := NewFileEventStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := events.Append("test", &Event{Restored: true}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			// a closed log is opened again by the next event
+			if err := events.Close("test"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// events that weren't synced yet are read back
+	if logged, err := events.Events("test"); err != nil || len(logged) != 3 {
+		t.Fatalf("read %d events back: %v", len(logged), err)
+	}
+	if err := events.Sync("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := events.Close("test"); err != nil {
+		t.Fatal(err)
+	}
+	if logged, err := events.Events("test"); err != nil || len(logged) != 3 {
+		t.Fatalf("read %d events back after closing: %v", len(logged), err)
+	}
+}
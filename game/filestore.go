@@ -0,0 +1,89 @@
+package game
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PuzzleStore persists the state of puzzles, so they survive restarts
+type PuzzleStore interface {
+	SavePuzzle(*PuzzleState) error
+
+	LoadPuzzles() ([]*PuzzleState, error)
+
+	DeletePuzzle(id string) error
+}
+
+// FilePuzzleStore implements PuzzleStore by saving each puzzle as a json file
+// in a directory
+type FilePuzzleStore struct {
+	dir string
+}
+
+// NewFilePuzzleStore creates a puzzle store in dir, creating it if necessary
+func NewFilePuzzleStore(dir string) (*FilePuzzleStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FilePuzzleStore{dir: dir}, nil
+}
+
+// SavePuzzle saves the state of a puzzle, replacing what was saved before
+func (s *FilePuzzleStore) SavePuzzle(state *PuzzleState) error {
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(state.ID), serialized)
+}
+
+// LoadPuzzles loads the state of every saved puzzle
+func (s *FilePuzzleStore) LoadPuzzles() ([]*PuzzleState, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]*PuzzleState, 0, len(files))
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		serialized, err := ioutil.ReadFile(filepath.Join(s.dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var state PuzzleState
+		if err := json.Unmarshal(serialized, &state); err != nil {
+			return nil, err
+		}
+		states = append(states, &state)
+	}
+	return states, nil
+}
+
+// DeletePuzzle deletes the saved state of a puzzle
+func (s *FilePuzzleStore) DeletePuzzle(id string) error {
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// path returns the file a puzzle is saved in
+func (s *FilePuzzleStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// writeFileAtomic replaces a file with data. It is written to a temporary file
+// first, so a crash can't leave a partial file
+func writeFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
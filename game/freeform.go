@@ -0,0 +1,236 @@
+package game
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// how far the table extends past each side of the image, as a fraction of
+	// the image's size
+	tableMargin = 0.5
+	// how close, in pieces, a piece has to be dropped to where it belongs to
+	// snap into place
+	snapTolerance = 0.25
+)
+
+// Rect represents a rectangle on the table, in pieces
+type Rect struct {
+	Min Point `json:"min"`
+	Max Point `json:"max"`
+}
+
+// newTable creates the table for a puzzle of ySize*xSize pieces. The image
+// covers (0, 0) to (xSize, ySize) on it
+func newTable(ySize int, xSize int) *Rect {
+	return &Rect{
+		Min: Point{X: -tableMargin * float64(xSize), Y: -tableMargin * float64(ySize)},
+		Max: Point{X: (1 + tableMargin) * float64(xSize), Y: (1 + tableMargin) * float64(ySize)}}
+}
+
+// scatter places every piece somewhere random on the table, away from where
+// it belongs
+func (p *Puzzle) scatter() {
+	for _, row := range p.Pieces {
+		for _, piece := range row {
+			dest := piece.DestPos.Point()
+			for {
+				pt := Point{
+					X: p.Table.Min.X + p.rng.Float64()*(p.Table.Max.X-p.Table.Min.X-1),
+					Y: p.Table.Min.Y + p.rng.Float64()*(p.Table.Max.Y-p.Table.Min.Y-1)}
+				if pt.Distance(dest) > snapTolerance {
+					piece.BoardPos = &pt
+					break
+				}
+			}
+		}
+	}
+}
+
+// heldPiece returns the piece at pos if it is held by the user in r
+func (p *Puzzle) heldPiece(r Request) (*Piece, error) {
+	if err := p.checkJoined(r.UserID); err != nil {
+		return nil, err
+	}
+	if p.Mode != FreeMode {
+		return nil, newError(ErrWrongMode, "pieces can only be moved in free mode")
+	}
+	if !p.inBounds(r.PiecePos) {
+		return nil, newError(ErrOutOfBounds, "piece x and y out of bounds")
+	}
+	piece := p.Pieces[r.PiecePos.Y][r.PiecePos.X]
+	if piece.HeldBy != r.UserID {
+		return nil, newError(ErrNotHolding, "piece isn't held by user")
+	}
+	return piece, nil
+}
+
+// pickUp picks up a piece in free mode, along with the rest of its group.
+// Picking up a piece that is already held by the user puts it back down where
+// it is
+func (p *Puzzle) pickUp(r Request) error {
+	if !p.inBounds(r.PiecePos) {
+		return newError(ErrOutOfBounds, "piece x and y out of bounds")
+	}
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+
+	piece := p.Pieces[r.PiecePos.Y][r.PiecePos.X]
+	if held := p.HeldPieces[r.UserID]; held != nil && piece.HeldBy == r.UserID {
+		return p.place(held, r.UserID, *held.BoardPos, false)
+	}
+	group := p.group(piece)
+	if heldByOther(group, r.UserID) {
+		return newError(ErrPieceHeld, "piece is held by another user")
+	}
+	if locked(group) {
+		return newError(ErrPieceLocked, "piece is locked")
+	}
+	if p.HeldPieces[r.UserID] != nil {
+		return newError(ErrAlreadyHolding, "user is already holding a piece")
+	}
+
+	p.LastUpdated = time.Now()
+	for _, member := range group {
+		member.HeldBy = r.UserID
+	}
+	p.HeldPieces[r.UserID] = piece
+	update := p.newUpdate(HOLD, r.UserID, piece.CurrPos, Position{}, 0)
+	update.BoardPos = piece.BoardPos
+	if len(group) > 1 {
+		update.Pieces = positions(group)
+	}
+	p.emit(update)
+	return nil
+}
+
+// move moves a held piece somewhere else on the table, along with its group
+func (p *Puzzle) move(r Request) error {
+	piece, err := p.heldPiece(r)
+	if err != nil {
+		return err
+	}
+
+	p.LastUpdated = time.Now()
+	group := p.group(piece)
+	pt := p.clamp(group, piece, r.BoardPos)
+	delta := p.moveTo(group, piece, pt)
+	points := p.score(r.UserID, delta, group)
+	update := p.newUpdate(MOVE, r.UserID, piece.CurrPos, Position{}, delta)
+	update.Points = points
+	update.BoardPos = &pt
+	if len(group) > 1 {
+		update.Pieces = positions(group)
+	}
+	p.emit(update)
+	return nil
+}
+
+// drop puts down a held piece, snapping it into place if it is close enough
+// to where it belongs
+func (p *Puzzle) drop(r Request) error {
+	piece, err := p.heldPiece(r)
+	if err != nil {
+		return err
+	}
+	return p.place(piece, r.UserID, r.BoardPos, true)
+}
+
+// place puts down a piece held by a user at pt, along with its group. If snap
+// is set, the group snaps to where it belongs, or to a neighbouring piece
+// when groups are on, if it is close enough
+func (p *Puzzle) place(piece *Piece, userID string, pt Point, snap bool) error {
+	p.LastUpdated = time.Now()
+	group := p.group(piece)
+	pt = p.clamp(group, piece, pt)
+	if snap {
+		pt = p.snap(group, piece, pt)
+	}
+	delta := p.moveTo(group, piece, pt)
+	p.release(userID)
+	points := p.score(userID, delta, group)
+	sizes := p.groupSizes(group)
+	p.regroup()
+
+	update := p.newUpdate(DROP, userID, piece.CurrPos, Position{}, delta)
+	update.Points = points
+	update.BoardPos = &pt
+	if len(group) > 1 {
+		update.Pieces = positions(group)
+	}
+	p.emit(update)
+	p.emitMerges(userID, sizes)
+	return nil
+}
+
+// snap returns where piece should be put down to be snapped into place, or
+// to a piece it would join, if it is close enough to either when put down at
+// pt. Otherwise, pt is returned
+func (p *Puzzle) snap(group []*Piece, piece *Piece, pt Point) Point {
+	if dest := piece.DestPos.Point(); pt.Distance(dest) <= snapTolerance {
+		return dest
+	}
+	if !p.options.Groups {
+		return pt
+	}
+
+	inGroup := make(map[*Piece]bool)
+	for _, member := range group {
+		inGroup[member] = true
+	}
+	for _, member := range group {
+		// where the member would be if piece was put down at pt
+		memberPt := Point{
+			X: pt.X + float64(member.DestPos.X-piece.DestPos.X),
+			Y: pt.Y + float64(member.DestPos.Y-piece.DestPos.Y)}
+		for _, neighbour := range p.neighbours(member) {
+			if inGroup[neighbour] || neighbour.HeldBy != "" {
+				continue
+			}
+			// where the member would be if it was joined to the neighbour
+			joinedPt := Point{
+				X: neighbour.BoardPos.X + float64(member.DestPos.X-neighbour.DestPos.X),
+				Y: neighbour.BoardPos.Y + float64(member.DestPos.Y-neighbour.DestPos.Y)}
+			if memberPt.Distance(joinedPt) <= snapTolerance {
+				return Point{
+					X: joinedPt.X + float64(piece.DestPos.X-member.DestPos.X),
+					Y: joinedPt.Y + float64(piece.DestPos.Y-member.DestPos.Y)}
+			}
+		}
+	}
+	return pt
+}
+
+// clamp returns the closest point to pt where piece can be put down, so that
+// its whole group stays on the table
+func (p *Puzzle) clamp(group []*Piece, piece *Piece, pt Point) Point {
+	min, max := p.Table.Min, Point{X: p.Table.Max.X - 1, Y: p.Table.Max.Y - 1}
+	for _, member := range group {
+		dx := float64(member.DestPos.X - piece.DestPos.X)
+		dy := float64(member.DestPos.Y - piece.DestPos.Y)
+		min.X, min.Y = math.Max(min.X, p.Table.Min.X-dx), math.Max(min.Y, p.Table.Min.Y-dy)
+		max.X, max.Y = math.Min(max.X, p.Table.Max.X-1-dx), math.Min(max.Y, p.Table.Max.Y-1-dy)
+	}
+	return Point{
+		X: math.Min(math.Max(pt.X, min.X), max.X),
+		Y: math.Min(math.Max(pt.Y, min.Y), max.Y)}
+}
+
+// moveTo moves piece to pt, keeping the rest of its group where it belongs
+// relative to it, and returns change in how many pieces are correct
+func (p *Puzzle) moveTo(group []*Piece, piece *Piece, pt Point) int {
+	delta := 0
+	for _, member := range group {
+		if member.Correct() {
+			delta--
+		}
+		member.BoardPos = &Point{
+			X: pt.X + float64(member.DestPos.X-piece.DestPos.X),
+			Y: pt.Y + float64(member.DestPos.Y-piece.DestPos.Y)}
+		if member.Correct() {
+			delta++
+		}
+	}
+	return delta
+}
@@ -0,0 +1,329 @@
+package game
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ilikerice123/puzzle/picture"
+)
+
+// how far apart, in pieces, two pieces on the table can be from where they
+// belong relative to each other and still count as joined
+const joinTolerance = 1e-6
+
+// Move represents a piece moving from one cell to another
+type Move struct {
+	From Position `json:"from"`
+	To   Position `json:"to"`
+}
+
+// unionFind is a disjoint set over piece ids
+type unionFind struct {
+	parent []int
+}
+
+// newUnionFind creates a union find where every id is in its own set
+func newUnionFind(size int) *unionFind {
+	u := &unionFind{parent: make([]int, size)}
+	for i := range u.parent {
+		u.parent[i] = i
+	}
+	return u
+}
+
+// find returns the root of the set id is in
+func (u *unionFind) find(id int) int {
+	for u.parent[id] != id {
+		u.parent[id] = u.parent[u.parent[id]]
+		id = u.parent[id]
+	}
+	return id
+}
+
+// union joins the sets a and b are in
+func (u *unionFind) union(a int, b int) {
+	rootA, rootB := u.find(a), u.find(b)
+	if rootA < rootB {
+		u.parent[rootB] = rootA
+	} else if rootB < rootA {
+		u.parent[rootA] = rootB
+	}
+}
+
+// group returns every piece joined to piece, including itself
+func (p *Puzzle) group(piece *Piece) []*Piece {
+	if p.members == nil {
+		return []*Piece{piece}
+	}
+	return p.members[piece.ID]
+}
+
+// regroup rebuilds the groups of joined pieces from where every piece is
+func (p *Puzzle) regroup() {
+	if !p.options.Groups {
+		return
+	}
+	groups := newUnionFind(p.Size)
+	for _, a := range p.byID {
+		for _, b := range p.neighbours(a) {
+			if a.ID < b.ID && p.joined(a, b) {
+				groups.union(a.ID, b.ID)
+			}
+		}
+	}
+
+	roots := make(map[int][]*Piece)
+	for _, piece := range p.byID {
+		root := groups.find(piece.ID)
+		roots[root] = append(roots[root], piece)
+	}
+	p.members = make([][]*Piece, p.Size)
+	p.Groups = make([][]Position, 0)
+	for _, piece := range p.byID {
+		group := roots[groups.find(piece.ID)]
+		p.members[piece.ID] = group
+		if len(group) > 1 && group[0] == piece {
+			p.Groups = append(p.Groups, positions(group))
+		}
+	}
+}
+
+// neighbours returns the pieces that belong next to piece
+func (p *Puzzle) neighbours(piece *Piece) []*Piece {
+	cells := p.topology.Neighbours(piece.DestPos)
+	neighbours := make([]*Piece, 0, len(cells))
+	for _, pos := range cells {
+		neighbours = append(neighbours, p.byID[pos.Y*p.XSize+pos.X])
+	}
+	return neighbours
+}
+
+// joined returns if two neighbouring pieces fit together, and are where they
+// belong relative to each other. Pieces only join once they are upright
+func (p *Puzzle) joined(a *Piece, b *Piece) bool {
+	if a.Rotation != 0 || b.Rotation != 0 {
+		return false
+	}
+	destX, destY := b.DestPos.X-a.DestPos.X, b.DestPos.Y-a.DestPos.Y
+	if !a.Fits(side(destX, destY), b) {
+		return false
+	}
+	if p.Mode == FreeMode {
+		return math.Abs(b.BoardPos.X-a.BoardPos.X-float64(destX)) < joinTolerance &&
+			math.Abs(b.BoardPos.Y-a.BoardPos.Y-float64(destY)) < joinTolerance
+	}
+	return b.CurrPos.X-a.CurrPos.X == destX && b.CurrPos.Y-a.CurrPos.Y == destY
+}
+
+// side returns which side of a piece its neighbour at offset (x, y) is on
+func side(x int, y int) int {
+	switch {
+	case y < 0:
+		return picture.Top
+	case x > 0:
+		return picture.Right
+	case y > 0:
+		return picture.Bottom
+	default:
+		return picture.Left
+	}
+}
+
+// groupSizes returns the size of the group each piece is in
+func (p *Puzzle) groupSizes(pieces []*Piece) map[*Piece]int {
+	sizes := make(map[*Piece]int)
+	for _, piece := range pieces {
+		sizes[piece] = len(p.group(piece))
+	}
+	return sizes
+}
+
+// emitMerges sends a MERGE update for every group that grew since sizes was
+// taken with groupSizes
+func (p *Puzzle) emitMerges(userID string, sizes map[*Piece]int) {
+	if !p.options.Groups {
+		return
+	}
+	pieces := make([]*Piece, 0, len(sizes))
+	for piece := range sizes {
+		pieces = append(pieces, piece)
+	}
+	merged := make(map[*Piece]bool)
+	for _, piece := range sortByID(pieces) {
+		group := p.group(piece)
+		if len(group) <= sizes[piece] || merged[group[0]] {
+			continue
+		}
+		merged[group[0]] = true
+		update := p.newUpdate(MERGE, userID, piece.CurrPos, Position{}, 0)
+		update.Pieces = positions(group)
+		p.emit(update)
+	}
+}
+
+// holdGroup is hold for puzzles with groups, where holding any piece holds its
+// whole group, and the whole group moves along with it
+func (p *Puzzle) holdGroup(r Request) error {
+	if !p.inBounds(r.PiecePos) {
+		return newError(ErrOutOfBounds, "piece x and y out of bounds")
+	}
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+
+	piece := p.Pieces[r.PiecePos.Y][r.PiecePos.X]
+	if piece.HeldBy != "" && piece.HeldBy != r.UserID {
+		return newError(ErrPieceHeld, "piece is held by another user")
+	}
+
+	held := p.HeldPieces[r.UserID]
+	if held == nil {
+		group := p.group(piece)
+		if heldByOther(group, r.UserID) {
+			return newError(ErrPieceHeld, "piece is held by another user")
+		}
+		if locked(group) {
+			return newError(ErrPieceLocked, "piece is locked")
+		}
+		p.LastUpdated = time.Now()
+		for _, member := range group {
+			member.HeldBy = r.UserID
+		}
+		p.HeldPieces[r.UserID] = piece
+		update := p.newUpdate(HOLD, r.UserID, piece.CurrPos, Position{}, 0)
+		update.Pieces = positions(group)
+		p.emit(update)
+		return nil
+	}
+
+	group := p.group(held)
+	for _, member := range group {
+		if member == piece {
+			// holding a piece of the held group releases it
+			p.LastUpdated = time.Now()
+			p.release(r.UserID)
+			update := p.newUpdate(SWAP, r.UserID, held.CurrPos, held.CurrPos, 0)
+			update.Pieces = positions(group)
+			p.emit(update)
+			return nil
+		}
+	}
+
+	offset := Position{X: piece.CurrPos.X - held.CurrPos.X, Y: piece.CurrPos.Y - held.CurrPos.Y}
+	delta, moves, err := p.moveBlock(group, offset, r.UserID)
+	if err != nil {
+		return err
+	}
+	p.LastUpdated = time.Now()
+	p.release(r.UserID)
+
+	affected := make([]*Piece, 0, len(moves))
+	for _, m := range moves {
+		affected = append(affected, p.Pieces[m.To.Y][m.To.X])
+	}
+	points := p.score(r.UserID, delta, affected)
+	sizes := p.groupSizes(affected)
+	p.regroup()
+
+	update := p.newUpdate(BLOCK, r.UserID, held.CurrPos, Position{}, delta)
+	update.Points = points
+	update.Moves = moves
+	p.emit(update)
+	p.emitMerges(r.UserID, sizes)
+	return nil
+}
+
+// moveBlock moves every piece in block by offset. Each piece that is in the
+// way is moved back along offset until it lands in a cell the block left, so
+// moving a single piece is the same as swapping it. Returns the change in how
+// many pieces are correct, and every move that was made
+func (p *Puzzle) moveBlock(block []*Piece, offset Position, userID string) (int, []Move, error) {
+	inBlock := make(map[*Piece]bool)
+	targets := make(map[Position]bool)
+	for _, piece := range block {
+		target := Position{X: piece.CurrPos.X + offset.X, Y: piece.CurrPos.Y + offset.Y}
+		if !p.inBounds(target) {
+			return 0, nil, newError(ErrOutOfBounds, "pieces would be moved out of bounds")
+		}
+		inBlock[piece] = true
+		targets[target] = true
+	}
+
+	destinations := make(map[*Piece]Position)
+	for _, piece := range block {
+		target := Position{X: piece.CurrPos.X + offset.X, Y: piece.CurrPos.Y + offset.Y}
+		destinations[piece] = target
+		displaced := p.Pieces[target.Y][target.X]
+		if inBlock[displaced] {
+			continue
+		}
+		if displaced.HeldBy != "" && displaced.HeldBy != userID {
+			return 0, nil, newError(ErrPieceHeld, "piece in the way is held by another user")
+		}
+		if displaced.Locked {
+			return 0, nil, newError(ErrPieceLocked, "piece in the way is locked")
+		}
+		vacated := Position{X: target.X - offset.X, Y: target.Y - offset.Y}
+		for targets[vacated] {
+			vacated = Position{X: vacated.X - offset.X, Y: vacated.Y - offset.Y}
+		}
+		destinations[displaced] = vacated
+	}
+
+	moved := make([]*Piece, 0, len(destinations))
+	for piece := range destinations {
+		moved = append(moved, piece)
+	}
+	delta := 0
+	moves := make([]Move, 0, len(moved))
+	for _, piece := range sortByID(moved) {
+		if piece.Correct() {
+			delta--
+		}
+		moves = append(moves, Move{From: piece.CurrPos, To: destinations[piece]})
+	}
+	for piece, dest := range destinations {
+		piece.CurrPos = dest
+		p.Pieces[dest.Y][dest.X] = piece
+		if piece.Correct() {
+			delta++
+		}
+	}
+	return delta, moves, nil
+}
+
+// release releases every piece held by a user
+func (p *Puzzle) release(userID string) {
+	for _, piece := range p.byID {
+		if piece.HeldBy == userID {
+			piece.HeldBy = ""
+		}
+	}
+	delete(p.HeldPieces, userID)
+}
+
+// heldByOther returns if any of the pieces are held by someone besides userID
+func heldByOther(pieces []*Piece, userID string) bool {
+	for _, piece := range pieces {
+		if piece.HeldBy != "" && piece.HeldBy != userID {
+			return true
+		}
+	}
+	return false
+}
+
+// positions returns the current position of every piece
+func positions(pieces []*Piece) []Position {
+	pos := make([]Position, len(pieces))
+	for i, piece := range pieces {
+		pos[i] = piece.CurrPos
+	}
+	return pos
+}
+
+// sortByID sorts pieces by id
+func sortByID(pieces []*Piece) []*Piece {
+	sort.Slice(pieces, func(i, j int) bool { return pieces[i].ID < pieces[j].ID })
+	return pieces
+}
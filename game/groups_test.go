@@ -0,0 +1,133 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMoveBlock(t *testing.T) {
+	tests := []struct {
+		name   string
+		block  []Position
+		offset Position
+		// want is the moves made, by the id of the piece that moved
+		want []Move
+	}{
+		{
+			name:   "single piece swaps",
+			block:  []Position{{Y: 0, X: 0}},
+			offset: Position{Y: 0, X: 1},
+			want: []Move{
+				{From: Position{Y: 0, X: 0}, To: Position{Y: 0, X: 1}},
+				{From: Position{Y: 0, X: 1}, To: Position{Y: 0, X: 0}}},
+		},
+		{
+			name:   "single piece jumps over a cell",
+			block:  []Position{{Y: 0, X: 0}},
+			offset: Position{Y: 0, X: 2},
+			want: []Move{
+				{From: Position{Y: 0, X: 0}, To: Position{Y: 0, X: 2}},
+				{From: Position{Y: 0, X: 2}, To: Position{Y: 0, X: 0}}},
+		},
+		{
+			name:   "row moved along itself",
+			block:  []Position{{Y: 0, X: 0}, {Y: 0, X: 1}},
+			offset: Position{Y: 0, X: 1},
+			want: []Move{
+				{From: Position{Y: 0, X: 0}, To: Position{Y: 0, X: 1}},
+				{From: Position{Y: 0, X: 1}, To: Position{Y: 0, X: 2}},
+				{From: Position{Y: 0, X: 2}, To: Position{Y: 0, X: 0}}},
+		},
+		{
+			name:   "column moved sideways",
+			block:  []Position{{Y: 0, X: 0}, {Y: 1, X: 0}},
+			offset: Position{Y: 0, X: 1},
+			want: []Move{
+				{From: Position{Y: 0, X: 0}, To: Position{Y: 0, X: 1}},
+				{From: Position{Y: 0, X: 1}, To: Position{Y: 0, X: 0}},
+				{From: Position{Y: 1, X: 0}, To: Position{Y: 1, X: 1}},
+				{From: Position{Y: 1, X: 1}, To: Position{Y: 1, X: 0}}},
+		},
+		{
+			name:   "corner moved diagonally",
+			block:  []Position{{Y: 0, X: 0}, {Y: 0, X: 1}, {Y: 1, X: 0}},
+			offset: Position{Y: 1, X: 1},
+			want: []Move{
+				{From: Position{Y: 0, X: 0}, To: Position{Y: 1, X: 1}},
+				{From: Position{Y: 0, X: 1}, To: Position{Y: 1, X: 2}},
+				{From: Position{Y: 1, X: 0}, To: Position{Y: 2, X: 1}},
+				{From: Position{Y: 1, X: 1}, To: Position{Y: 0, X: 0}},
+				{From: Position{Y: 1, X: 2}, To: Position{Y: 0, X: 1}},
+				{From: Position{Y: 2, X: 1}, To: Position{Y: 1, X: 0}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPuzzle(3, 3, Options{}, newTestUsers())
+			block := make([]*Piece, len(test.block))
+			for i, pos := range test.block {
+				block[i] = p.at(pos)
+			}
+			delta, moves, err := p.moveBlock(block, test.offset, "u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(moves, test.want) {
+				t.Errorf("moves are %v, want %v", moves, test.want)
+			}
+			if delta != -len(test.want) {
+				t.Errorf("delta is %d, want %d", delta, -len(test.want))
+			}
+			for _, move := range moves {
+				piece := p.at(move.To)
+				if piece.CurrPos != move.To || piece.DestPos != move.From {
+					t.Errorf("piece %d that belongs at %v is at %v", piece.ID, piece.DestPos, piece.CurrPos)
+				}
+			}
+		})
+	}
+}
+
+func TestMoveBlockBlocked(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset Position
+		setup  func(p *Puzzle)
+		code   ErrorCode
+	}{
+		{
+			name:   "out of bounds",
+			offset: Position{Y: -1, X: 0},
+			setup:  func(*Puzzle) {},
+			code:   ErrOutOfBounds,
+		},
+		{
+			name:   "held by another user",
+			offset: Position{Y: 0, X: 1},
+			setup:  func(p *Puzzle) { p.at(Position{Y: 0, X: 1}).HeldBy = "u2" },
+			code:   ErrPieceHeld,
+		},
+		{
+			name:   "locked",
+			offset: Position{Y: 1, X: 0},
+			setup:  func(p *Puzzle) { p.at(Position{Y: 1, X: 0}).Locked = true },
+			code:   ErrPieceLocked,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPuzzle(3, 3, Options{}, newTestUsers())
+			test.setup(p)
+			block := []*Piece{p.at(Position{Y: 0, X: 0})}
+			_, _, err := p.moveBlock(block, test.offset, "u1")
+			if perr, ok := err.(*Error); !ok || perr.Code != test.code {
+				t.Fatalf("error is %v, want %s", err, test.code)
+			}
+			for _, piece := range p.byID {
+				if !piece.Correct() {
+					t.Errorf("piece %d moved to %v", piece.ID, piece.CurrPos)
+				}
+			}
+		})
+	}
+}
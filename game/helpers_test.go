@@ -0,0 +1,89 @@
+package game
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ilikerice123/puzzle/fs"
+	"github.com/ilikerice123/puzzle/store"
+)
+
+// newTestUsers creates a user pool holding a user for every id, which never
+// falls back to the store
+func newTestUsers(ids ...string) *UserPool {
+	users := &UserPool{users: make(map[string]*store.User), missing: make(map[string]time.Time)}
+	for _, id := range ids {
+		users.AddUser(&store.User{ID: id, PieceCount: make(map[string]int)})
+	}
+	return users
+}
+
+// newTestPuzzle creates a solved ySize*xSize puzzle without images, which
+// doesn't send its updates anywhere
+func newTestPuzzle(ySize int, xSize int, options Options, users UserPoolBase) *Puzzle {
+	if options.Seed == 0 {
+		options.Seed = 1
+	}
+	state := &PuzzleState{
+		ID:      "test",
+		YSize:   ySize,
+		XSize:   xSize,
+		Options: options,
+		Pieces:  make([]PieceState, ySize*xSize)}
+	for id := range state.Pieces {
+		state.Pieces[id] = PieceState{
+			CurrPos:   Position{Y: id / xSize, X: id % xSize},
+			ImageFile: fmt.Sprintf("piece_%d.png", id)}
+	}
+	p := RestorePuzzle(state, nil, users)
+	p.countCorrect()
+	return p
+}
+
+// arrange swaps the pieces at each pair of positions, and recounts which
+// pieces are correct. Like after a shuffle, only pieces left correct count as
+// placed
+func arrange(p *Puzzle, pairs ...[2]Position) {
+	for _, pair := range pairs {
+		p.swap(p.at(pair[0]), p.at(pair[1]))
+	}
+	p.placed = make([]bool, p.Size)
+	p.countCorrect()
+	p.regroup()
+}
+
+// at returns the piece at pos
+func (p *Puzzle) at(pos Position) *Piece {
+	return p.Pieces[pos.Y][pos.X]
+}
+
+// do does a request on a puzzle, failing the test if it errors
+func do(t *testing.T, p PuzzleBase, r Request) []*Update {
+	t.Helper()
+	updates, err := p.Do(r)
+	if err != nil {
+		t.Fatalf("action %d by %s: %s", r.Action, r.UserID, err.Error())
+	}
+	return updates
+}
+
+// newTestImage saves an image to cut puzzles from in a temporary directory,
+// and returns its file name
+func newTestImage(t *testing.T) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 120, 90))
+	for x := 0; x < 120; x++ {
+		for y := 0; y < 90; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 2), G: uint8(y * 2), B: 100, A: 255})
+		}
+	}
+	file := filepath.Join(t.TempDir(), "original.jpeg")
+	if err := fs.SaveImage(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
@@ -0,0 +1,36 @@
+package game
+
+import "time"
+
+// hint reveals where the piece a user is holding belongs, or which piece
+// belongs in the cell at the request's position if they aren't holding one.
+// Every user can take as many hints as the puzzle allows, and each one costs
+// them the puzzle's hint cost
+func (p *Puzzle) hint(r Request) error {
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+	if p.hintsUsed[r.UserID] >= p.options.Hints {
+		return newError(ErrNoHints, "user has no hints left")
+	}
+
+	piece := p.HeldPieces[r.UserID]
+	if piece == nil {
+		if !p.inBounds(r.PiecePos) {
+			return newError(ErrOutOfBounds, "piece x and y out of bounds")
+		}
+		piece = p.byID[r.PiecePos.Y*p.XSize+r.PiecePos.X]
+	}
+	if piece.Correct() {
+		return newError(ErrAlreadyCorrect, "piece is already where it belongs")
+	}
+
+	p.LastUpdated = time.Now()
+	p.hintsUsed[r.UserID]++
+	p.award(r.UserID, -p.options.HintCost)
+	update := p.newUpdate(HINT, r.UserID, piece.CurrPos, piece.DestPos, 0)
+	update.Points = -p.options.HintCost
+	update.HintsLeft = p.options.Hints - p.hintsUsed[r.UserID]
+	p.emit(update)
+	return nil
+}
@@ -1,20 +1,56 @@
 package game
 
-import "sync"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// UpdateLogSize is how many of the most recent updates are kept around to be
+// replayed to reconnecting clients
+const UpdateLogSize = 1024
+
+// errStopped is returned for tasks given to a puzzle that was stopped
+var errStopped = errors.New("puzzle is stopped")
 
 // LivePuzzleBase represents a threadsafe puzzle object
 type LivePuzzleBase interface {
 	Start()
 
+	Stop()
+
 	AddRequest(*Request)
 
-	AddCallback(func(*Update))
+	Connect(userID string, team string) error
+
+	Disconnect(userID string)
+
+	Subscribe(context.Context, func(*Update)) *Subscription
+
+	SubscribeSince(context.Context, int, func(*Update)) (*Subscription, bool)
+
+	Subscribers() int
+
+	Spectate(*Subscription)
+
+	Spectators() int
+
+	Snapshot() (*Update, int)
 
 	ID() string
 
-	Results() map[string]int
+	Results() Results
 
 	Complete() bool
+
+	Save(PuzzleStore) error
+
+	Record(events EventStore, restored bool) error
+
+	Archive(results ResultStore) error
 }
 
 // LivePuzzle implements the LivePuzzleBase interface
@@ -23,8 +59,28 @@ type LivePuzzle struct {
 
 	requests     chan *Request
 	updates      chan *Update
-	callbacks    []func(*Update)
+	callbacks    map[*Subscription]func(*Update)
 	callbackLock sync.Locker
+	// spectators are the subscriptions of clients watching without playing,
+	// guarded by callbackLock
+	spectators map[*Subscription]bool
+	history    *UpdateLog
+	// tasks are run by the same goroutine as requests, so they see a
+	// consistent puzzle
+	tasks chan func()
+	// stopped is closed once the puzzle is stopped, after which requests and
+	// tasks are dropped
+	stopped  chan struct{}
+	stopOnce sync.Once
+	// next update id when the puzzle was last saved, only used by tasks
+	savedUpdateID int
+	// where requests are logged, only used by the requests goroutine
+	events EventStore
+	// where final results are archived, only used by the requests goroutine
+	results ResultStore
+	// connections is how many connections every user has open, only used by
+	// the requests goroutine
+	connections map[string]int
 }
 
 // NewLivePuzzle creates new live puzzle
@@ -33,19 +89,44 @@ func NewLivePuzzle(
 	file string,
 	ySize int,
 	xSize int,
+	options Options,
 	users UserPoolBase) *LivePuzzle {
 	updates := make(chan *Update)
-	p := NewPuzzle(id, file, ySize, xSize, updates, users)
+	p := NewPuzzle(id, file, ySize, xSize, options, updates, users)
 	if p == nil {
 		return nil
 	}
+	return newLivePuzzle(p, updates, -1)
+}
+
+// RestoreLivePuzzle creates a live puzzle from the state it was saved in
+func RestoreLivePuzzle(state *PuzzleState, users UserPoolBase) *LivePuzzle {
+	updates := make(chan *Update)
+	p := RestorePuzzle(state, updates, users)
+	return newLivePuzzle(p, updates, state.NextUpdateID)
+}
 
+// newLivePuzzle wraps a puzzle that sends its updates to the updates channel
+// savedUpdateID is the next update id of the puzzle when it was last saved,
+// or -1 if it was never saved
+func newLivePuzzle(p PuzzleBase, updates chan *Update, savedUpdateID int) *LivePuzzle {
+	history := NewUpdateLog(UpdateLogSize)
+	if savedUpdateID >= 0 {
+		// updates from before the puzzle was saved are gone
+		history.nextID = savedUpdateID
+	}
 	return &LivePuzzle{
-		Puzzle:       p,
-		requests:     make(chan *Request),
-		updates:      updates,
-		callbacks:    make([]func(*Update), 0),
-		callbackLock: &sync.Mutex{}}
+		Puzzle:        p,
+		requests:      make(chan *Request),
+		updates:       updates,
+		callbacks:     make(map[*Subscription]func(*Update)),
+		spectators:    make(map[*Subscription]bool),
+		callbackLock:  &sync.Mutex{},
+		history:       history,
+		tasks:         make(chan func()),
+		stopped:       make(chan struct{}),
+		savedUpdateID: savedUpdateID,
+		connections:   make(map[string]int)}
 }
 
 // ID returns the id of the puzzle
@@ -53,40 +134,380 @@ func (p *LivePuzzle) ID() string {
 	return p.Puzzle.GetID()
 }
 
-// Results returns the results of the puzzle
-func (p *LivePuzzle) Results() map[string]int {
-	return p.Puzzle.Results()
+// Results returns the results of the puzzle. The puzzle must be started
+func (p *LivePuzzle) Results() Results {
+	result := make(chan Results)
+	if !p.run(func() { result <- p.Puzzle.Results() }) {
+		return Results{}
+	}
+	return <-result
 }
 
-// Complete returns whether the puzzle is complete
+// Complete returns whether the puzzle is complete. The puzzle must be started
 func (p *LivePuzzle) Complete() bool {
-	return p.Puzzle.Complete()
+	result := make(chan bool)
+	if !p.run(func() { result <- p.Puzzle.Complete() }) {
+		return false
+	}
+	return <-result
 }
 
-// AddRequest adds a request to the LivePuzzle
+// AddRequest adds a request to the LivePuzzle. Requests to a stopped puzzle
+// are dropped
 func (p *LivePuzzle) AddRequest(r *Request) {
-	p.requests <- r
+	select {
+	case p.requests <- r:
+	case <-p.stopped:
+	}
+}
+
+// Connect joins a user, and a team if it isn't empty, through a new
+// connection, and returns the error the JOIN was rejected with, if it was. A
+// user can have several connections open at once, like one resuming another
+// that didn't time out yet, and only joins with the first
+func (p *LivePuzzle) Connect(userID string, team string) error {
+	result := make(chan error)
+	if !p.run(func() {
+		if p.connections[userID] > 0 {
+			p.connections[userID]++
+			result <- nil
+			return
+		}
+		err := p.do(&Request{Action: JOIN, UserID: userID, Team: team, internal: true})
+		if err == nil {
+			// a rejected connection isn't counted, so it doesn't leave
+			p.connections[userID] = 1
+		}
+		result <- err
+	}) {
+		return errStopped
+	}
+	return <-result
 }
 
-// AddCallback registers a function callback
-func (p *LivePuzzle) AddCallback(f func(*Update)) {
+// Disconnect closes a connection of a user, who leaves once every connection
+// of theirs is closed
+func (p *LivePuzzle) Disconnect(userID string) {
+	p.run(func() {
+		if p.connections[userID] == 0 {
+			return
+		}
+		p.connections[userID]--
+		if p.connections[userID] == 0 {
+			delete(p.connections, userID)
+			p.do(&Request{Action: LEAVE, UserID: userID, internal: true})
+		}
+	})
+}
+
+// Subscribe registers a function callback, which is called with every update
+// until the returned subscription is closed or ctx is cancelled
+func (p *LivePuzzle) Subscribe(ctx context.Context, f func(*Update)) *Subscription {
 	p.callbackLock.Lock()
-	p.callbacks = append(p.callbacks, f)
-	p.callbackLock.Unlock()
+	defer p.callbackLock.Unlock()
+	return p.subscribe(ctx, f)
+}
+
+// SubscribeSince registers a function callback like Subscribe, after first
+// calling it with every update newer than the update id since. If those
+// updates are no longer available, it is called with a snapshot of the puzzle
+// instead, and false is returned. The puzzle must be started
+func (p *LivePuzzle) SubscribeSince(
+	ctx context.Context,
+	since int,
+	f func(*Update)) (*Subscription, bool) {
+	type subscribed struct {
+		s  *Subscription
+		ok bool
+	}
+	result := make(chan subscribed)
+	if !p.run(func() {
+		p.flush()
+		p.callbackLock.Lock()
+		defer p.callbackLock.Unlock()
+		missed, ok := p.history.Since(since)
+		if ok {
+			for _, update := range missed {
+				f(update)
+			}
+		} else {
+			f(p.snapshot())
+		}
+		result <- subscribed{s: p.subscribe(ctx, f), ok: ok}
+	}) {
+		return nil, false
+	}
+	r := <-result
+	return r.s, r.ok
+}
+
+// Subscribers returns how many subscriptions are currently open
+func (p *LivePuzzle) Subscribers() int {
+	p.callbackLock.Lock()
+	defer p.callbackLock.Unlock()
+	return len(p.callbacks)
+}
+
+// Spectate marks a subscription as a spectator's, who watches the puzzle
+// without joining it. Closed subscriptions are ignored
+func (p *LivePuzzle) Spectate(s *Subscription) {
+	p.callbackLock.Lock()
+	defer p.callbackLock.Unlock()
+	if _, open := p.callbacks[s]; open {
+		p.spectators[s] = true
+	}
+}
+
+// Spectators returns how many of the open subscriptions are spectators'
+func (p *LivePuzzle) Spectators() int {
+	p.callbackLock.Lock()
+	defer p.callbackLock.Unlock()
+	return len(p.spectators)
+}
+
+// MarshalJSON serializes the puzzle along with its subscriber and spectator
+// counts. The puzzle must be started
+func (p *LivePuzzle) MarshalJSON() ([]byte, error) {
+	type marshalled struct {
+		puzzle json.RawMessage
+		err    error
+	}
+	result := make(chan marshalled)
+	if !p.run(func() {
+		puzzle, err := json.Marshal(p.Puzzle)
+		result <- marshalled{puzzle: puzzle, err: err}
+	}) {
+		return nil, errStopped
+	}
+	r := <-result
+	if r.err != nil {
+		return nil, r.err
+	}
+	return json.Marshal(struct {
+		Puzzle      json.RawMessage
+		Subscribers int `json:"subscribers"`
+		Spectators  int `json:"spectators"`
+	}{r.puzzle, p.Subscribers(), p.Spectators()})
+}
+
+// subscribe adds a callback, callbackLock must be held
+func (p *LivePuzzle) subscribe(ctx context.Context, f func(*Update)) *Subscription {
+	var s *Subscription
+	s = newSubscription(ctx, func() {
+		p.callbackLock.Lock()
+		delete(p.callbacks, s)
+		delete(p.spectators, s)
+		p.callbackLock.Unlock()
+	})
+	p.callbacks[s] = f
+	return s
+}
+
+// Save saves the state of the puzzle to a store, if it changed since it was
+// last saved, or its clock is running, and it can be saved. A running clock is
+// saved as of when it is saved, so it can be stopped from there while the
+// server is down. The puzzle must be started
+func (p *LivePuzzle) Save(store PuzzleStore) error {
+	result := make(chan error)
+	if !p.run(func() {
+		state := p.Puzzle.State()
+		if state == nil {
+			result <- nil
+			return
+		}
+		running := state.Clock != nil && state.Clock.State == ClockRunning
+		if state.NextUpdateID == p.savedUpdateID && !running {
+			result <- nil
+			return
+		}
+		if running {
+			state.Clock.set(ClockRunning, time.Now().Round(0))
+		}
+		// the log has to have every request the saved state includes
+		p.syncEvents()
+		err := store.SavePuzzle(state)
+		if err == nil {
+			p.savedUpdateID = state.NextUpdateID
+		}
+		result <- err
+	}) {
+		return errStopped
+	}
+	return <-result
+}
+
+// Record starts logging every request the puzzle does, along with the updates
+// it caused, to events. If the puzzle doesn't have a log yet, its current state
+// is logged as its genesis. restored should be set if the puzzle was just
+// restored, otherwise the puzzle is new, and it can't have a log yet. Puzzles
+// that can't be saved aren't logged either. The puzzle must be started
+func (p *LivePuzzle) Record(events EventStore, restored bool) error {
+	result := make(chan error)
+	if !p.run(func() {
+		if p.Puzzle.State() == nil {
+			result <- nil
+			return
+		}
+		existing, err := events.Events(p.ID())
+		if err != nil {
+			result <- err
+			return
+		}
+		if len(existing) == 0 {
+			err = events.Append(p.ID(), &Event{Time: time.Now(), Genesis: p.Puzzle.State()})
+		} else if !restored {
+			err = newError(ErrPuzzleExists, "puzzle already has a log")
+		} else {
+			restored := &Event{Time: time.Now(), Restored: true, Clock: p.Puzzle.State().Clock}
+			err = events.Append(p.ID(), restored)
+		}
+		if err == nil {
+			p.events = events
+		}
+		result <- err
+	}) {
+		return errStopped
+	}
+	return <-result
+}
+
+// Archive starts saving the final results of the puzzle to results, once it
+// is complete. The puzzle must be started
+func (p *LivePuzzle) Archive(results ResultStore) error {
+	result := make(chan error)
+	if !p.run(func() {
+		p.results = results
+		result <- nil
+	}) {
+		return errStopped
+	}
+	return <-result
+}
+
+// do stamps a request with the time, does it, logs it, archives the final
+// results it caused, and replies to it. Returns the error the request was
+// rejected with. Only used by the requests goroutine
+func (p *LivePuzzle) do(req *Request) error {
+	req.Time = time.Now().Round(0)
+	updates, err := p.Puzzle.Do(*req)
+	if p.events != nil && len(updates) > 0 {
+		e := &Event{Time: req.Time, Request: req, Internal: req.internal, Updates: updates}
+		if err := p.events.Append(p.ID(), e); err != nil {
+			log.Printf("Error logging request to puzzle %s: %s", p.ID(), err.Error())
+		}
+	}
+	for _, update := range updates {
+		if p.results != nil && update.Final != nil {
+			if err := p.results.SaveResults(update.Final); err != nil {
+				log.Printf("Error archiving results of puzzle %s: %s", p.ID(), err.Error())
+			}
+		}
+	}
+	if req.RequestID != "" && req.OnReply != nil {
+		req.OnReply(newAck(req.RequestID, updates, err))
+	}
+	return err
+}
+
+// syncEvents flushes the requests logged since the last tick to disk. Only
+// used by the requests goroutine
+func (p *LivePuzzle) syncEvents() {
+	if p.events == nil {
+		return
+	}
+	if err := p.events.Sync(p.ID()); err != nil {
+		log.Printf("Error syncing log of puzzle %s: %s", p.ID(), err.Error())
+	}
+}
+
+// Snapshot returns a SNAPSHOT update holding the current state of the puzzle,
+// and the id of the first update it doesn't include. The puzzle must be
+// started, and Snapshot can't be called by subscribers, who would block the
+// puzzle's updates while it waits for them to be sent out
+func (p *LivePuzzle) Snapshot() (*Update, int) {
+	type snapshotted struct {
+		update *Update
+		nextID int
+	}
+	result := make(chan snapshotted)
+	if !p.run(func() {
+		p.flush()
+		p.callbackLock.Lock()
+		defer p.callbackLock.Unlock()
+		result <- snapshotted{update: p.snapshot(), nextID: p.history.nextID}
+	}) {
+		return nil, 0
+	}
+	r := <-result
+	return r.update, r.nextID
+}
+
+// snapshot returns a SNAPSHOT update holding the current state of the puzzle.
+// The puzzle is serialized right away, so the update can be sent out after it
+// changes. Only used by the requests goroutine
+func (p *LivePuzzle) snapshot() *Update {
+	puzzle, err := json.Marshal(p.Puzzle)
+	if err != nil {
+		log.Printf("Error serializing puzzle %s: %s", p.ID(), err.Error())
+	}
+	return &Update{ID: -1, Action: SNAPSHOT, Puzzle: puzzle}
+}
+
+// flush waits until every update the puzzle sent was sent out to subscribers.
+// Only used by the requests goroutine
+func (p *LivePuzzle) flush() {
+	// the updates goroutine only takes nil once it is done with every update
+	// before it
+	p.updates <- nil
+}
+
+// run gives a task to the requests goroutine, and returns if it will be run,
+// which it won't once the puzzle is stopped
+func (p *LivePuzzle) run(task func()) bool {
+	select {
+	case p.tasks <- task:
+		return true
+	case <-p.stopped:
+		return false
+	}
 }
 
 // Start starts the puzzle
 func (p *LivePuzzle) Start() {
-	// goroutine to process requests
+	// goroutine to process requests, and to tick the puzzle's clock
 	go func() {
-		for req := range p.requests {
-			p.Puzzle.Do(*req)
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		// ends the goroutine sending updates too
+		defer close(p.updates)
+		for {
+			select {
+			case req := <-p.requests:
+				p.do(req)
+			case <-ticker.C:
+				p.do(&Request{Action: TICK, internal: true})
+				p.syncEvents()
+			case task := <-p.tasks:
+				task()
+			case <-p.stopped:
+				if p.events != nil {
+					if err := p.events.Close(p.ID()); err != nil {
+						log.Printf("Error closing log of puzzle %s: %s", p.ID(), err.Error())
+					}
+				}
+				return
+			}
 		}
 	}()
 	// goroutine to send updates
 	go func() {
 		for update := range p.updates {
+			if update == nil {
+				// sent by flush
+				continue
+			}
 			p.callbackLock.Lock()
+			p.history.Append(update)
 			for _, f := range p.callbacks {
 				f(update)
 			}
@@ -94,3 +515,11 @@ func (p *LivePuzzle) Start() {
 		}
 	}()
 }
+
+// Stop stops the puzzle's clock ticking, closes its log, and ends its
+// goroutines. Requests and tasks given to it after are dropped
+func (p *LivePuzzle) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopped)
+	})
+}
@@ -0,0 +1,183 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder records the updates of a live puzzle
+type recorder struct {
+	lock    sync.Mutex
+	updates []*Update
+}
+
+// record subscribes to the updates of a live puzzle
+func record(p *LivePuzzle) *recorder {
+	r := &recorder{}
+	p.Subscribe(context.Background(), func(u *Update) {
+		r.lock.Lock()
+		r.updates = append(r.updates, u)
+		r.lock.Unlock()
+	})
+	return r
+}
+
+// actions returns the action of every update sent out so far, and forgets
+// them
+func (r *recorder) actions(p *LivePuzzle) []action {
+	// snapshots are taken once every earlier update was sent out
+	p.Snapshot()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	actions := make([]action, len(r.updates))
+	for i, u := range r.updates {
+		actions[i] = u.Action
+	}
+	r.updates = nil
+	return actions
+}
+
+// newTestLivePuzzle starts a live puzzle of a shuffled 2*2 puzzle
+func newTestLivePuzzle(t *testing.T, options Options, users UserPoolBase) *LivePuzzle {
+	shuffled := newTestPuzzle(2, 2, options, users)
+	shuffled.Shuffle()
+	p := RestoreLivePuzzle(shuffled.State(), users)
+	p.Start()
+	t.Cleanup(p.Stop)
+	return p
+}
+
+// connect connects a user to a live puzzle, failing the test if they can't
+// join
+func connect(t *testing.T, p *LivePuzzle, userID string, team string) {
+	t.Helper()
+	if err := p.Connect(userID, team); err != nil {
+		t.Fatalf("connecting %s: %s", userID, err.Error())
+	}
+}
+
+func TestConnect(t *testing.T) {
+	p := newTestLivePuzzle(t, Options{}, newTestUsers("u1"))
+	r := record(p)
+
+	err := p.Connect("u1", strings.Repeat("x", maxTeamName+1))
+	if CodeOf(err) != ErrInvalidTeam {
+		t.Fatalf("error is %v, want %s", err, ErrInvalidTeam)
+	}
+	// the rejected connection closing doesn't make the user leave
+	p.Disconnect("u1")
+	if actions := r.actions(p); len(actions) != 0 {
+		t.Fatalf("rejected connection sent %v", actions)
+	}
+
+	if err := p.Connect("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Connect("u1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if actions := r.actions(p); len(actions) != 2 || actions[0] != JOIN || actions[1] != START {
+		t.Fatalf("connecting twice sent %v, want a JOIN and a START", actions)
+	}
+	p.Disconnect("u1")
+	if actions := r.actions(p); len(actions) != 0 {
+		t.Fatalf("closing one of two connections sent %v", actions)
+	}
+	p.Disconnect("u1")
+	if actions := r.actions(p); len(actions) != 1 || actions[0] != LEAVE {
+		t.Fatalf("closing the last connection sent %v, want a LEAVE", actions)
+	}
+	if err := CodeOf(p.Connect("u2", "")); err != ErrUnknownUser {
+		t.Fatalf("unknown user connected with %s, want %s", err, ErrUnknownUser)
+	}
+
+	// clients can't join or leave by themselves
+	for _, a := range []action{JOIN, LEAVE} {
+		result := make(chan *Ack, 1)
+		p.AddRequest(&Request{Action: a, UserID: "u1", RequestID: "1", OnReply: func(a *Ack) { result <- a }})
+		if ack := <-result; ack.Code != ErrUnknownAction {
+			t.Errorf("client sent action %d was answered with %+v", a, ack)
+		}
+	}
+	if actions := r.actions(p); len(actions) != 0 {
+		t.Errorf("client sent JOIN and LEAVE sent %v", actions)
+	}
+}
+
+// request adds a request with a request id to a live puzzle, and returns the
+// ack it was answered with
+func request(p *LivePuzzle, r Request) *Ack {
+	result := make(chan *Ack, 1)
+	r.RequestID = "1"
+	r.OnReply = func(a *Ack) { result <- a }
+	p.AddRequest(&r)
+	return <-result
+}
+
+func TestAcks(t *testing.T) {
+	p := newTestLivePuzzle(t, Options{}, newTestUsers("u1"))
+	r := record(p)
+	connect(t, p, "u1", "")
+	r.actions(p)
+
+	ack := request(p, Request{Action: HOLD, UserID: "u1", PiecePos: Position{Y: 0, X: 0}})
+	// snapshots are taken once every earlier update was sent out
+	p.Snapshot()
+	r.lock.Lock()
+	held := r.updates[len(r.updates)-1]
+	r.lock.Unlock()
+	if ack.Action != ACK || ack.RequestID != "1" || ack.UpdateID != held.ID || held.Action != HOLD {
+		t.Errorf("HOLD was answered with %+v, after update %+v", ack, held)
+	}
+	ack = request(p, Request{Action: HOLD, UserID: "u1", PiecePos: Position{Y: 5, X: 0}})
+	if ack.Action != NACK || ack.Code != ErrOutOfBounds || ack.UpdateID != -1 || ack.Error == "" {
+		t.Errorf("HOLD out of bounds was answered with %+v", ack)
+	}
+	// requests without a request id aren't answered
+	replied := false
+	p.AddRequest(&Request{Action: HOLD, UserID: "u1", PiecePos: Position{Y: 0, X: 0}, OnReply: func(*Ack) { replied = true }})
+	if r.actions(p); replied {
+		t.Error("request without a request id was answered")
+	}
+}
+
+func TestSpectators(t *testing.T) {
+	p := newTestLivePuzzle(t, Options{}, newTestUsers("u1"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watched := make(chan *Update, 10)
+	s := p.Subscribe(ctx, func(u *Update) { watched <- u })
+	p.Spectate(s)
+	if p.Spectators() != 1 || p.Subscribers() != 1 {
+		t.Fatalf("%d spectators out of %d subscribers, want 1 out of 1", p.Spectators(), p.Subscribers())
+	}
+
+	// spectators see every update, without joining
+	connect(t, p, "u1", "")
+	if u := <-watched; u.Action != JOIN || u.UserID != "u1" {
+		t.Errorf("spectator saw %+v, want u1 joining", u)
+	}
+	snapshot, _ := p.Snapshot()
+	var state struct {
+		CurrentUsers map[string]interface{} `json:"currentUsers"`
+	}
+	if err := json.Unmarshal(snapshot.Puzzle, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.CurrentUsers) != 1 {
+		t.Errorf("current users are %v, want only u1", state.CurrentUsers)
+	}
+
+	s.Close()
+	if p.Spectators() != 0 || p.Subscribers() != 0 {
+		t.Errorf("%d spectators out of %d subscribers after closing", p.Spectators(), p.Subscribers())
+	}
+	// closed subscriptions can't spectate
+	p.Spectate(s)
+	if p.Spectators() != 0 {
+		t.Errorf("closed subscription is spectating")
+	}
+}
@@ -0,0 +1,57 @@
+package game
+
+import "time"
+
+// locked returns if any piece of a group is locked
+func locked(group []*Piece) bool {
+	for _, piece := range group {
+		if piece.Locked {
+			return true
+		}
+	}
+	return false
+}
+
+// lock locks a piece that was put where it belongs, if correct pieces are
+// locked, and sends it out with the next update
+func (p *Puzzle) lock(piece *Piece) {
+	if !p.options.LockCorrect {
+		return
+	}
+	piece.Locked = true
+	p.locked = append(p.locked, piece.CurrPos)
+}
+
+// unlock unlocks a piece, along with its group, so it can be moved again. Only
+// the host can unlock pieces, and they stay unlocked until they are put where
+// they belong again
+func (p *Puzzle) unlock(r Request) error {
+	if !p.options.LockCorrect {
+		return newError(ErrWrongMode, "pieces are only locked when correct pieces are locked")
+	}
+	if !p.inBounds(r.PiecePos) {
+		return newError(ErrOutOfBounds, "piece x and y out of bounds")
+	}
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+	if r.UserID != p.options.Host {
+		return newError(ErrNotHost, "only the host can unlock pieces")
+	}
+
+	piece := p.Pieces[r.PiecePos.Y][r.PiecePos.X]
+	group := p.group(piece)
+	if !locked(group) {
+		return newError(ErrNotLocked, "piece isn't locked")
+	}
+	p.LastUpdated = time.Now()
+	for _, member := range group {
+		member.Locked = false
+	}
+	update := p.newUpdate(UNLOCK, r.UserID, piece.CurrPos, Position{}, 0)
+	if len(group) > 1 {
+		update.Pieces = positions(group)
+	}
+	p.emit(update)
+	return nil
+}
@@ -0,0 +1,139 @@
+package game
+
+import "time"
+
+// Mode is how pieces are placed on the board
+type Mode int
+
+// modes a puzzle can be played in
+const (
+	// GridMode swaps pieces between the cells of a fixed grid
+	GridMode Mode = iota
+	// FreeMode lets pieces be moved anywhere on a table larger than the image,
+	// and snaps them into place when dropped close to where they belong
+	FreeMode
+)
+
+// Cut is how the image of a puzzle is cut into pieces
+type Cut int
+
+// cuts a puzzle's image can be cut with
+const (
+	// RectCut cuts the image into rectangles
+	RectCut Cut = iota
+	// JigsawCut cuts the image into jigsaw pieces with tabs and blanks
+	JigsawCut
+)
+
+// Shuffle is how the pieces of a puzzle are shuffled when it is created
+type Shuffle int
+
+// shuffles a puzzle can be created with
+const (
+	// DerangeShuffle shuffles every piece away from where it belongs
+	DerangeShuffle Shuffle = iota
+	// PartialShuffle leaves InPlace percent of the pieces where they belong,
+	// and shuffles the rest away from where they belong
+	PartialShuffle
+	// LocalShuffle keeps every piece within Distance cells of where it
+	// belongs, both across and down
+	LocalShuffle
+	// RowShuffle shuffles pieces within the row they belong in
+	RowShuffle
+	// ColumnShuffle shuffles pieces within the column they belong in
+	ColumnShuffle
+	// EdgesSolvedShuffle leaves the pieces on the border where they belong,
+	// and shuffles the rest
+	EdgesSolvedShuffle
+)
+
+// Options are the optional settings a puzzle is created with
+// - if Groups is set, pieces that are joined where they belong relative to
+//   each other are held and moved together as a group
+// - if Rotation is set, pieces start turned randomly, and have to be rotated
+//   upright to be correct
+// - InPlace and Distance tune the shuffle, see Shuffle
+// - if TimeLimit is set, pieces can't be moved once the puzzle's clock ran for
+//   that many seconds
+// - if Race is set, every player gets their own board, shuffled the same way,
+//   and the first to finish wins. If TimeLimit is set, the player with the
+//   most pieces correct after that many seconds wins instead
+// - Scoring is the ruleset users score points by. Without one, users get a
+//   point for every piece they put where it belongs, and lose one for every
+//   piece they move out of place
+// - if LockCorrect is set, pieces are locked once they are where they belong,
+//   and can't be moved until Host, the id of the user hosting the puzzle,
+//   unlocks them. Host is required if LockCorrect is set
+// - Hints is how many hints every user can take, and HintCost how many points
+//   each one costs them
+// - Layout is the shape of the pieces. Hexagons and triangles can only be
+//   played in grid mode with a rectangular cut, without groups or rotation
+// - Seed seeds every random choice made creating the puzzle, so puzzles
+//   created from the same image with the same options are identical. 0 means
+//   a random seed is used
+type Options struct {
+	Mode        Mode     `json:"mode"`
+	Groups      bool     `json:"groups"`
+	Cut         Cut      `json:"cut"`
+	Rotation    bool     `json:"rotation"`
+	Seed        int64    `json:"seed"`
+	Shuffle     Shuffle  `json:"shuffle"`
+	InPlace     int      `json:"inPlace"`
+	Distance    int      `json:"distance"`
+	Race        bool     `json:"race"`
+	TimeLimit   int      `json:"timeLimit"`
+	Scoring     *Ruleset `json:"scoring,omitempty"`
+	LockCorrect bool     `json:"lockCorrect"`
+	Host        string   `json:"host,omitempty"`
+	Hints       int      `json:"hints"`
+	HintCost    int      `json:"hintCost"`
+	Layout      Layout   `json:"layout"`
+}
+
+// maxSeed bounds generated seeds, so they survive being a javascript number
+const maxSeed = 1 << 53
+
+// NewSeed returns a random seed to create a puzzle with, which is never 0
+func NewSeed() int64 {
+	return time.Now().UnixNano()%maxSeed + 1
+}
+
+// Validate checks that the options are valid
+func (o Options) Validate() error {
+	if o.Mode != GridMode && o.Mode != FreeMode {
+		return newError(ErrInvalidOptions, "unknown mode")
+	}
+	if o.Cut != RectCut && o.Cut != JigsawCut {
+		return newError(ErrInvalidOptions, "unknown cut")
+	}
+	if o.Layout < RectLayout || o.Layout > TriangleLayout {
+		return newError(ErrInvalidOptions, "unknown layout")
+	}
+	if o.Layout != RectLayout && (o.Mode != GridMode || o.Cut != RectCut || o.Groups || o.Rotation) {
+		return newError(ErrInvalidOptions, "only rectangles can be played in free mode, cut as jigsaw pieces, grouped or rotated")
+	}
+	if o.Shuffle < DerangeShuffle || o.Shuffle > EdgesSolvedShuffle {
+		return newError(ErrInvalidOptions, "unknown shuffle")
+	}
+	if o.Shuffle == PartialShuffle && (o.InPlace < 0 || o.InPlace >= 100) {
+		return newError(ErrInvalidOptions, "inPlace must be a percentage below 100")
+	}
+	if o.Shuffle == LocalShuffle && o.Distance < 1 {
+		return newError(ErrInvalidOptions, "distance must be at least 1")
+	}
+	if o.TimeLimit < 0 {
+		return newError(ErrInvalidOptions, "timeLimit can't be negative")
+	}
+	if o.LockCorrect && o.Host == "" {
+		return newError(ErrInvalidOptions, "lockCorrect needs a host to unlock pieces")
+	}
+	if o.Hints < 0 || o.HintCost < 0 {
+		return newError(ErrInvalidOptions, "hints and hintCost can't be negative")
+	}
+	if o.Scoring != nil {
+		if err := o.Scoring.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
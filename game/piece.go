@@ -1,18 +1,41 @@
 package game
 
+import (
+	"math"
+
+	"github.com/ilikerice123/puzzle/picture"
+)
+
 // Position represents a 2d position on the puzzle
 type Position struct {
 	X int `json:"X"`
 	Y int `json:"Y"`
 }
 
+// Point represents a 2d position on the table of a free mode puzzle, in pieces
+type Point struct {
+	X float64 `json:"X"`
+	Y float64 `json:"Y"`
+}
+
 // Piece represents a single puzzle piece
+// in free mode, CurrPos never changes after shuffling, and only identifies the
+// piece, while BoardPos is where its top left corner is on the table
+// Edges is only populated for puzzles with a jigsaw cut, and doesn't take the
+// piece's rotation into account
+// Rotation is how many quarter turns clockwise the piece is turned
+// Locked pieces are where they belong, and can't be moved until the host
+// unlocks them
 type Piece struct {
-	DestPos   Position `json:"-"`
-	CurrPos   Position `json:"currPos"`
-	ID        int      `json:"-"`
-	ImageFile string   `json:"image"`
-	HeldBy    string   `json:"heldBy"`
+	DestPos   Position       `json:"-"`
+	CurrPos   Position       `json:"currPos"`
+	BoardPos  *Point         `json:"boardPos,omitempty"`
+	ID        int            `json:"-"`
+	ImageFile string         `json:"image"`
+	HeldBy    string         `json:"heldBy"`
+	Edges     *picture.Edges `json:"edges,omitempty"`
+	Rotation  int            `json:"rotation"`
+	Locked    bool           `json:"locked,omitempty"`
 }
 
 // Equals compares different positions
@@ -20,7 +43,42 @@ func (p Position) Equals(other Position) bool {
 	return p.X == other.X && p.Y == other.Y
 }
 
-// Correct returns if piece is in the correct position
+// Point returns where a piece in this position would be on the table
+func (p Position) Point() Point {
+	return Point{X: float64(p.X), Y: float64(p.Y)}
+}
+
+// Distance returns the distance between two points
+func (pt Point) Distance(other Point) float64 {
+	return math.Hypot(pt.X-other.X, pt.Y-other.Y)
+}
+
+// Correct returns if piece is in the correct position, and upright
 func (p Piece) Correct() bool {
+	if p.Rotation != 0 {
+		return false
+	}
+	if p.BoardPos != nil {
+		return *p.BoardPos == p.DestPos.Point()
+	}
 	return p.DestPos.Equals(p.CurrPos)
 }
+
+// Fits returns if the piece fits against other, when other is placed on side
+// of it, as they are currently rotated. Pieces without edges fit against
+// anything
+func (p Piece) Fits(side int, other *Piece) bool {
+	if p.Edges == nil || other.Edges == nil {
+		return true
+	}
+	return p.rotatedEdges().Fits(side, other.rotatedEdges())
+}
+
+// rotatedEdges returns the edges of the piece as it is currently rotated
+func (p Piece) rotatedEdges() picture.Edges {
+	var rotated picture.Edges
+	for side := range rotated {
+		rotated[(side+p.Rotation)%4] = p.Edges[side]
+	}
+	return rotated
+}
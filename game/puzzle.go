@@ -1,7 +1,6 @@
 package game
 
 import (
-	"fmt"
 	"math/rand"
 	"time"
 
@@ -13,7 +12,7 @@ import (
 
 // PuzzleBase is an interface for the base Puzzle object
 type PuzzleBase interface {
-	Do(r Request) error
+	Do(r Request) ([]*Update, error)
 
 	Shuffle()
 
@@ -23,9 +22,12 @@ type PuzzleBase interface {
 
 	GetID() string
 
-	Results() map[string]int
+	Results() Results
 
 	OnComplete()
+
+	// State returns nil for puzzles that can't be saved
+	State() *PuzzleState
 }
 
 // Puzzle representing a non-threadsafe puzzle objec that implements PuzzleBase
@@ -42,19 +44,84 @@ type Puzzle struct {
 	ImageHeight   int                    `json:"imageHeight"`
 	LastUpdated   time.Time              `json:"lastUpdated"`
 	CurrentUsers  map[string]*store.User `json:"currentUsers"`
-	updates       chan<- *Update
-	users         UserPoolBase
+	Mode          Mode                   `json:"mode"`
+	Table         *Rect                  `json:"table,omitempty"`
+	Groups        [][]Position           `json:"groups,omitempty"`
+	Teams         map[string]*Team       `json:"teams"`
+	Clock         *Clock                 `json:"clock"`
+	PieceMargin   int                    `json:"pieceMargin"`
+	Seed          int64                  `json:"seed"`
+	Scoring       *Ruleset               `json:"scoring,omitempty"`
+	LockCorrect   bool                   `json:"lockCorrect,omitempty"`
+	Host          string                 `json:"host,omitempty"`
+	Layout        Layout                 `json:"layout"`
+	options       Options
+	// topology is the shape of the board's cells, and which are next to each
+	// other
+	topology Topology
+	// policy scores the moves of users
+	policy ScoringPolicy
+	// rng is seeded with Seed, so puzzles with the same seed are shuffled the
+	// same way
+	rng     *rand.Rand
+	byID    []*Piece
+	members [][]*Piece
+	// teamOf is the name of the team of every user on one
+	teamOf map[string]string
+	// correct is whether every piece was correct as of the last move scored,
+	// and placed whether it was ever put where it belongs, by id
+	correct []bool
+	placed  []bool
+	// streaks is how many moves in a row every user put pieces where they
+	// belong
+	streaks map[string]int
+	// moves counts the moves made, touched is the move that last moved every
+	// piece, by id, and lastSwaps is the last swap of every user, so it can
+	// be undone, or nil if their last move can't be. They aren't saved, so
+	// swaps can't be undone after a restart
+	moves     int
+	touched   []int
+	lastSwaps map[string]*swapRecord
+	// hintsUsed is how many hints every user took
+	hintsUsed map[string]int
+	// scores of every user that joined the puzzle, including ones that left
+	scores  map[string]int
+	updates chan<- *Update
+	users   UserPoolBase
+	// state of the request currently being done
+	requestID   string
+	requestTime time.Time
+	emitted     []*Update
+	// locked are the pieces the request locked, sent out with the next update
+	locked []Position
 }
 
-// NewPuzzle creates the new puzzle from the file string of an image
+// NewPuzzle creates the new puzzle from the file string of an image. If the
+// seed in options is 0, a random one is used
 func NewPuzzle(
 	id string,
 	file string,
 	ySize int,
 	xSize int,
+	options Options,
 	updatesChannel chan<- *Update,
 	users UserPoolBase) *Puzzle {
-	pieceNames, err := picture.SliceImage(file, ySize, xSize)
+	var pieceNames [][]string
+	var edges [][]picture.Edges
+	var margin int
+	var err error
+	if options.Seed == 0 {
+		options.Seed = NewSeed()
+	}
+	rng := rand.New(rand.NewSource(options.Seed))
+	topology := newTopology(options.Layout, ySize, xSize)
+	if options.Cut == JigsawCut {
+		pieceNames, edges, margin, err = picture.CutJigsaw(file, ySize, xSize, rng)
+	} else if options.Layout != RectLayout {
+		pieceNames, err = picture.CutTiles(file, topology, ySize, xSize)
+	} else {
+		pieceNames, err = picture.SliceImage(file, ySize, xSize)
+	}
 	if err != nil {
 		return nil
 	}
@@ -72,12 +139,37 @@ func NewPuzzle(
 		NextUpdateID:  0,
 		LastUpdated:   time.Now(),
 		CurrentUsers:  make(map[string]*store.User),
+		Teams:         make(map[string]*Team),
+		teamOf:        make(map[string]string),
+		scores:        make(map[string]int),
+		streaks:       make(map[string]int),
+		hintsUsed:     make(map[string]int),
+		lastSwaps:     make(map[string]*swapRecord),
+		Clock:         &Clock{State: ClockWaiting, TimeLimit: options.TimeLimit},
 		updates:       updatesChannel,
 		users:         users,
 		XSize:         xSize,
 		YSize:         ySize,
 		ImageWidth:    imageWidth,
 		ImageHeight:   imageHeight,
+		Mode:          options.Mode,
+		PieceMargin:   margin,
+		Seed:          options.Seed,
+		Scoring:       options.Scoring,
+		LockCorrect:   options.LockCorrect,
+		Host:          options.Host,
+		Layout:        options.Layout,
+		options:       options,
+		topology:      topology,
+		policy:        newScoringPolicy(options.Scoring),
+		rng:           rng,
+		byID:          make([]*Piece, ySize*xSize),
+		correct:       make([]bool, ySize*xSize),
+		placed:        make([]bool, ySize*xSize),
+		touched:       make([]int, ySize*xSize),
+	}
+	if options.Mode == FreeMode {
+		puzzle.Table = newTable(ySize, xSize)
 	}
 
 	for i := range puzzle.Pieces {
@@ -89,9 +181,17 @@ func NewPuzzle(
 				ID:        i*xSize + j,
 				HeldBy:    "",
 				ImageFile: pieceNames[i][j]}
+			if edges != nil {
+				puzzle.Pieces[i][j].Edges = &edges[i][j]
+			}
+			puzzle.byID[i*xSize+j] = puzzle.Pieces[i][j]
 		}
 	}
 	puzzle.Shuffle()
+	if options.Rotation {
+		puzzle.randomizeRotations()
+	}
+	puzzle.regroup()
 
 	return &puzzle
 }
@@ -101,52 +201,98 @@ func (p *Puzzle) GetID() string {
 	return p.ID
 }
 
-// Do does the request on the puzzle
-func (p *Puzzle) Do(r Request) error {
-	if p.PiecesCorrect == p.Size {
-		return fmt.Errorf("puzzle complete")
+// Do does the request on the puzzle, and returns the updates it caused
+func (p *Puzzle) Do(r Request) ([]*Update, error) {
+	p.requestID = r.RequestID
+	p.requestTime = requestTime(r)
+	p.emitted = nil
+	p.locked = nil
+	complete := p.Complete()
+	err := p.do(r)
+	if !complete && p.Complete() {
+		p.OnComplete()
+	}
+	return p.emitted, err
+}
+
+// do does the request on the puzzle. Complete puzzles are read only, users can
+// only join and leave them
+func (p *Puzzle) do(r Request) error {
+	if p.Complete() && r.Action != JOIN && r.Action != LEAVE {
+		return newError(ErrPuzzleComplete, "puzzle complete")
+	}
+
+	switch r.Action {
+	case HOLD, MOVE, DROP, ROTATE, HINT, UNDO, BATCH:
+		if err := p.checkClock(); err != nil {
+			return err
+		}
 	}
 
 	switch r.Action {
 	case HOLD:
+		if p.Mode == FreeMode {
+			return p.pickUp(r)
+		}
+		if p.options.Groups {
+			return p.holdGroup(r)
+		}
 		return p.hold(r)
-	case JOIN:
-		return p.addUser(r.UserID)
-	case LEAVE:
+	case MOVE:
+		return p.move(r)
+	case DROP:
+		return p.drop(r)
+	case ROTATE:
+		return p.rotate(r)
+	case JOIN, LEAVE:
+		// users join and leave as their connections open and close
+		if !r.internal {
+			return newError(ErrUnknownAction, "unknown action")
+		}
+		if r.Action == JOIN {
+			return p.addUser(r.UserID, r.Team)
+		}
 		p.removeUser(r.UserID)
 		return nil
+	case PAUSE:
+		return p.pause(r)
+	case RESUME:
+		return p.resume(r)
+	case TICK:
+		return p.tick(r)
+	case UNLOCK:
+		return p.unlock(r)
+	case HINT:
+		return p.hint(r)
+	case UNDO:
+		return p.undo(r)
+	case BATCH:
+		return p.batch(r)
 	default:
-		return fmt.Errorf("unknown action")
-	}
-}
-
-// Shuffle shuffles the puzzle based on Fisher–Yates shuffle, modified so
-// piecesCorrect == 0 every time
-func (p *Puzzle) Shuffle() {
-	for i := range p.Pieces {
-		for j := range p.Pieces[i] {
-			if i == len(p.Pieces)-1 && j == len(p.Pieces[i])-1 {
-				return
-			}
-			currIdx := i*(p.XSize) + j
-			idx := rand.Intn((p.Size)-currIdx-1) + currIdx + 1
-
-			// log.Printf("swapping (%d,%d) with (%d,%d)\n", i, j, k, l)
-			p.swap(p.Pieces[i][j], p.Pieces[idx/p.XSize][idx%p.XSize])
-		}
+		return newError(ErrUnknownAction, "unknown action")
 	}
 }
 
-// OnComplete executes completion logic
+// OnComplete stops the clock, and sends out a COMPLETE update with the final
+// results of the puzzle
 func (p *Puzzle) OnComplete() {
-
+	p.Clock.set(ClockStopped, p.requestTime)
+	update := p.newClockUpdate(COMPLETE, "")
+	update.Final = newFinalResults(p.ID, p.requestTime, p.Results(), p.teamOf, p.users)
+	p.emit(update)
 }
 
-// Results returns the results of the puzzle
-func (p *Puzzle) Results() map[string]int {
-	results := make(map[string]int)
-	for userID, user := range p.CurrentUsers {
-		results[userID] = user.PieceCount[p.ID]
+// Results returns the results of the puzzle, including users that left
+func (p *Puzzle) Results() Results {
+	results := Results{
+		Users:   make(map[string]int),
+		Teams:   make(map[string]int),
+		Elapsed: p.Clock.elapsed(time.Now())}
+	for userID, score := range p.scores {
+		results.Users[userID] = score
+	}
+	for name, team := range p.Teams {
+		results.Teams[name] = team.Score
 	}
 	return results
 }
@@ -162,18 +308,20 @@ func (p *Puzzle) LastUpdatedTime() time.Time {
 }
 
 func (p *Puzzle) hold(r Request) error {
-	if r.PiecePos.Y >= p.YSize || r.PiecePos.X >= p.XSize {
-		return fmt.Errorf("piece x and y out of bounds")
+	if !p.inBounds(r.PiecePos) {
+		return newError(ErrOutOfBounds, "piece x and y out of bounds")
 	}
-	reqUser, exists := p.CurrentUsers[r.UserID]
-	if !exists {
-		return fmt.Errorf("puzzle's current users doesn't include user id")
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
 	}
 
 	piece := p.Pieces[r.PiecePos.Y][r.PiecePos.X]
 	if piece.HeldBy != "" && piece.HeldBy != r.UserID {
-		// piece is being held by someone else, no-op
-		return nil
+		// piece is being held by someone else, who has to release it first
+		return newError(ErrPieceHeld, "piece is held by another user")
+	}
+	if held := p.HeldPieces[r.UserID]; piece != held && (piece.Locked || held != nil && held.Locked) {
+		return newError(ErrPieceLocked, "piece is locked")
 	}
 
 	p.LastUpdated = time.Now()
@@ -181,52 +329,92 @@ func (p *Puzzle) hold(r Request) error {
 		// hold, update held pieces
 		piece.HeldBy = r.UserID
 		p.HeldPieces[r.UserID] = piece
-		p.updates <- p.newUpdate(HOLD, r.UserID, piece.CurrPos, Position{}, 0)
+		p.emit(p.newUpdate(HOLD, r.UserID, piece.CurrPos, Position{}, 0))
 		return nil
 	}
 
 	otherPiece := p.HeldPieces[r.UserID]
+	if !p.topology.SameShape(piece.CurrPos, otherPiece.CurrPos) {
+		return newError(ErrWrongShape, "pieces of different shapes can't be swapped")
+	}
+	streak := p.streaks[r.UserID]
+	placed := [2]bool{p.placed[piece.ID], p.placed[otherPiece.ID]}
 	delta := p.swap(piece, otherPiece)
 	// swap (or release if same as held piece)
 	// TODO: needs to be after swap for some reason, or else the pointer is gone? what?
 	delete(p.HeldPieces, r.UserID)
-	p.PiecesCorrect += delta
-	if p.Complete() {
-		p.OnComplete()
+	points := p.score(r.UserID, delta, []*Piece{piece, otherPiece})
+	if piece != otherPiece {
+		p.recordSwap(r.UserID, piece, otherPiece, delta, points, streak, placed)
+	} else {
+		// releasing a piece isn't a move
+		delete(p.lastSwaps, r.UserID)
 	}
 
-	// update user stats
-	reqUser.PieceCount[p.ID] = reqUser.PieceCount[p.ID] + delta
-	reqUser.LifetimePieces += delta
-
-	p.updates <- p.newUpdate(SWAP, r.UserID, piece.CurrPos, otherPiece.CurrPos, delta)
+	update := p.newUpdate(SWAP, r.UserID, piece.CurrPos, otherPiece.CurrPos, delta)
+	update.Points = points
+	p.emit(update)
 	return nil
 }
 
-// addUser adds a user to current users
-func (p *Puzzle) addUser(id string) error {
+// addUser adds a user to current users, and to team if it isn't empty
+func (p *Puzzle) addUser(id string, team string) error {
 	u := p.users.GetUser(id)
 	if u == nil {
-		return fmt.Errorf("user not registered in pool")
+		return newError(ErrUnknownUser, "user not registered in pool")
 	}
 
 	if _, exists := p.CurrentUsers[u.ID]; exists {
-		return fmt.Errorf("user already exists")
+		return newError(ErrAlreadyJoined, "user already exists")
+	}
+	if err := p.checkTeam(u.ID, team); err != nil {
+		return err
 	}
+	// users that rejoin keep their score
+	u.PieceCount[p.ID] = p.scores[u.ID]
+	p.scores[u.ID] = u.PieceCount[p.ID]
 	p.CurrentUsers[u.ID] = u
-	p.updates <- p.newUpdate(JOIN, id, Position{}, Position{}, 0)
+	p.joinTeam(u.ID, team)
+	p.emit(p.newUpdate(JOIN, id, Position{}, Position{}, 0))
+	p.startClock(id)
 	return nil
 }
 
-// removeUser removes a user from current users
+// removeUser removes a user from current users, if they are one
 func (p *Puzzle) removeUser(id string) {
-	delete(p.CurrentUsers, id)
+	if _, joined := p.CurrentUsers[id]; !joined {
+		return
+	}
 	if heldPiece, exists := p.HeldPieces[id]; exists {
-		heldPiece.HeldBy = ""
-		p.updates <- p.newUpdate(SWAP, id, heldPiece.CurrPos, heldPiece.CurrPos, 0)
+		if p.Mode == FreeMode {
+			p.place(heldPiece, id, *heldPiece.BoardPos, false)
+		} else {
+			group := p.group(heldPiece)
+			p.release(id)
+			update := p.newUpdate(SWAP, id, heldPiece.CurrPos, heldPiece.CurrPos, 0)
+			if len(group) > 1 {
+				update.Pieces = positions(group)
+			}
+			p.emit(update)
+		}
 	}
+	delete(p.CurrentUsers, id)
 	delete(p.HeldPieces, id)
-	p.updates <- p.newUpdate(LEAVE, id, Position{}, Position{}, 0)
+	p.emit(p.newUpdate(LEAVE, id, Position{}, Position{}, 0))
+}
+
+// checkJoined returns an error if a user isn't one of the puzzle's current
+// users
+func (p *Puzzle) checkJoined(userID string) error {
+	if _, exists := p.CurrentUsers[userID]; !exists {
+		return newError(ErrNotJoined, "puzzle's current users doesn't include user id")
+	}
+	return nil
+}
+
+// inBounds returns if pos is a cell of the puzzle
+func (p *Puzzle) inBounds(pos Position) bool {
+	return pos.Y >= 0 && pos.X >= 0 && pos.Y < p.YSize && pos.X < p.XSize
 }
 
 // swap swaps piece1 and 2, and returns change in how many pieces are correct
@@ -249,10 +437,10 @@ func (p *Puzzle) swap(piece1 *Piece, piece2 *Piece) int {
 	piece1.HeldBy = ""
 	piece2.HeldBy = ""
 
-	if piece1.CurrPos.Equals(piece1.DestPos) {
+	if piece1.Correct() {
 		delta++
 	}
-	if piece2.CurrPos.Equals(piece2.DestPos) {
+	if piece2.Correct() {
 		delta++
 	}
 	return delta
@@ -272,5 +460,22 @@ func (p *Puzzle) newUpdate(
 		UserID:    userID,
 		Piece1Pos: piece1,
 		Piece2Pos: piece2,
-		Delta:     delta}
+		Delta:     delta,
+		RequestID: p.requestID}
+}
+
+// emit sends an update out, and records it as caused by the current request
+func (p *Puzzle) emit(u *Update) {
+	if len(p.locked) > 0 {
+		u.Locked = p.locked
+		p.locked = nil
+	}
+	if name, exists := p.teamOf[u.UserID]; exists {
+		u.Team = name
+		u.TeamScore = p.Teams[name].Score
+	}
+	p.emitted = append(p.emitted, u)
+	if p.updates != nil {
+		p.updates <- u
+	}
 }
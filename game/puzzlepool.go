@@ -3,58 +3,222 @@ package game
 import (
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/ilikerice123/puzzle/fs"
 )
 
+// how often live puzzles are saved
+const saveInterval = time.Minute
+
 // PuzzlePoolBase represents a PuzzlePool interface
 type PuzzlePoolBase interface {
-	AddPuzzle(LivePuzzleBase)
+	Reserve(id string) error
+
+	Release(id string)
+
+	AddPuzzle(LivePuzzleBase) error
 
 	GetPuzzle(id string) LivePuzzleBase
 
 	Prune()
+
+	SaveAll()
+
+	Events(id string) ([]*Event, error)
+
+	FinalResults(id string) (*FinalResults, error)
+
+	UserResults(userID string) ([]*UserResult, error)
 }
 
 // PuzzlePool represents the pool of interactable puzzles
 type PuzzlePool struct {
 	puzzles map[string]LivePuzzleBase
+	// reserved are the ids of puzzles being created
+	reserved map[string]bool
+	store    PuzzleStore
+	events   EventStore
+	results  ResultStore
+	lock     sync.RWMutex
 }
 
 // GlobalPuzzlePool represents all the puzzles
 var GlobalPuzzlePool PuzzlePoolBase
 
-// InitPuzzlePool assigns value to globalUserPool
-func InitPuzzlePool() {
-	GlobalPuzzlePool = NewPuzzlePool()
+// InitPuzzlePool assigns value to globalUserPool, restoring every puzzle
+// saved in store
+func InitPuzzlePool(store PuzzleStore, events EventStore, results ResultStore) {
+	GlobalPuzzlePool = NewPuzzlePool(store, events, results)
 }
 
-// NewPuzzlePool creates a new puzzle pool
-func NewPuzzlePool() *PuzzlePool {
-	p := &PuzzlePool{puzzles: make(map[string]LivePuzzleBase)}
+// NewPuzzlePool creates a new puzzle pool that saves its puzzles to store,
+// logs them to events, and archives their final results to results, and
+// restores the puzzles that are already saved there
+func NewPuzzlePool(store PuzzleStore, events EventStore, results ResultStore) *PuzzlePool {
+	p := &PuzzlePool{
+		puzzles:  make(map[string]LivePuzzleBase),
+		reserved: make(map[string]bool),
+		store:    store,
+		events:   events,
+		results:  results}
+	states, err := store.LoadPuzzles()
+	if err != nil {
+		log.Printf("Error loading saved puzzles: %s", err.Error())
+	}
+	for _, state := range states {
+		puzzle := RestoreLivePuzzle(p.catchUp(state), GlobalUserPool)
+		puzzle.Start()
+		if err := puzzle.Record(events, true); err != nil {
+			log.Printf("Error logging puzzle %s: %s", puzzle.ID(), err.Error())
+		}
+		if err := puzzle.Archive(results); err != nil {
+			log.Printf("Error archiving puzzle %s: %s", puzzle.ID(), err.Error())
+		}
+		p.puzzles[puzzle.ID()] = puzzle
+	}
+	log.Printf("Restored %d puzzles", len(p.puzzles))
+
 	scheduler := time.NewTicker(12 * time.Hour)
 	go func() {
 		for range scheduler.C {
 			p.Prune()
 		}
 	}()
+	saver := time.NewTicker(saveInterval)
+	go func() {
+		for range saver.C {
+			p.SaveAll()
+		}
+	}()
 	return p
 }
 
-// AddPuzzle adds a puzzle to the pool
-func (p *PuzzlePool) AddPuzzle(puzzle LivePuzzleBase) {
+// Reserve reserves an id for a puzzle being created, until it is added or the
+// id is released. An id can't be reserved while it is reserved already, or
+// taken by a live puzzle or one that was logged before
+func (p *PuzzlePool) Reserve(id string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, exists := p.puzzles[id]; exists || p.reserved[id] {
+		return newError(ErrPuzzleExists, "puzzle already exists")
+	}
+	events, err := p.events.Events(id)
+	if err != nil {
+		return err
+	}
+	if len(events) > 0 {
+		return newError(ErrPuzzleExists, "puzzle already has a log")
+	}
+	p.reserved[id] = true
+	return nil
+}
+
+// Release releases an id reserved for a puzzle that wasn't added
+func (p *PuzzlePool) Release(id string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.reserved, id)
+}
+
+// AddPuzzle adds a puzzle to the pool, saves it, and starts logging it and
+// archiving its final results. A puzzle can't take the id of one that is live,
+// or that was logged before. It takes over the reservation of its id, if there
+// is one
+func (p *PuzzlePool) AddPuzzle(puzzle LivePuzzleBase) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, exists := p.puzzles[puzzle.ID()]; exists {
+		return newError(ErrPuzzleExists, "puzzle already exists")
+	}
+	if err := puzzle.Record(p.events, false); err != nil {
+		if CodeOf(err) == ErrPuzzleExists {
+			return err
+		}
+		log.Printf("Error logging puzzle %s: %s", puzzle.ID(), err.Error())
+	}
+	if err := puzzle.Archive(p.results); err != nil {
+		log.Printf("Error archiving puzzle %s: %s", puzzle.ID(), err.Error())
+	}
+	if err := puzzle.Save(p.store); err != nil {
+		log.Printf("Error saving puzzle %s: %s", puzzle.ID(), err.Error())
+	}
+	delete(p.reserved, puzzle.ID())
 	p.puzzles[puzzle.ID()] = puzzle
+	return nil
+}
+
+// Events returns the log of a puzzle, even if it was already pruned
+func (p *PuzzlePool) Events(id string) ([]*Event, error) {
+	return p.events.Events(id)
+}
+
+// FinalResults returns the archived final results of a puzzle, even if it was
+// already pruned, or nil if it wasn't completed
+func (p *PuzzlePool) FinalResults(id string) (*FinalResults, error) {
+	return p.results.LoadResults(id)
+}
+
+// UserResults returns the final results of every puzzle a user completed
+func (p *PuzzlePool) UserResults(userID string) ([]*UserResult, error) {
+	return p.results.UserResults(userID)
+}
+
+// catchUp returns the state of a puzzle after every request in its log, if
+// the log has requests the saved state is missing, like after a crash
+func (p *PuzzlePool) catchUp(state *PuzzleState) *PuzzleState {
+	events, err := p.events.Events(state.ID)
+	if err != nil {
+		log.Printf("Error loading log of puzzle %s: %s", state.ID, err.Error())
+		return state
+	}
+	lastUpdateID := -1
+	for _, e := range events {
+		if len(e.Updates) > 0 {
+			lastUpdateID = e.Updates[len(e.Updates)-1].ID
+		}
+	}
+	if lastUpdateID < state.NextUpdateID {
+		return state
+	}
+
+	puzzle, err := ReplayPuzzle(events, -1)
+	if err != nil {
+		log.Printf("Error replaying log of puzzle %s: %s", state.ID, err.Error())
+		return state
+	}
+	return puzzle.State()
 }
 
 // GetPuzzle gets a puzzle from the pool
 func (p *PuzzlePool) GetPuzzle(id string) LivePuzzleBase {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	return p.puzzles[id]
 }
 
-// Prune removes all puzzles that are complete, including their images
-// also removes all directories that doesn't have a puzzle associated to it
+// SaveAll saves every puzzle that changed since it was last saved
+func (p *PuzzlePool) SaveAll() {
+	p.lock.RLock()
+	puzzles := make([]LivePuzzleBase, 0, len(p.puzzles))
+	for _, puzzle := range p.puzzles {
+		puzzles = append(puzzles, puzzle)
+	}
+	p.lock.RUnlock()
+	for _, puzzle := range puzzles {
+		if err := puzzle.Save(p.store); err != nil {
+			log.Printf("Error saving puzzle %s: %s", puzzle.ID(), err.Error())
+		}
+	}
+}
+
+// Prune removes and stops all puzzles that are complete, including their
+// images and saved state. Also removes all directories that doesn't have a
+// puzzle associated to it
 func (p *PuzzlePool) Prune() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	for id, puzzle := range p.puzzles {
 		if puzzle.Complete() {
 			// remove directory for puzzle
@@ -62,13 +226,18 @@ func (p *PuzzlePool) Prune() {
 			if fs.DirExists(dir) {
 				os.RemoveAll(dir)
 			}
+			forgetTimelapse(id)
+			if err := p.store.DeletePuzzle(id); err != nil {
+				log.Printf("Error deleting saved puzzle %s: %s", id, err.Error())
+			}
 			// remove active puzzles from user
-			for userID := range puzzle.Results() {
+			for userID := range puzzle.Results().Users {
 				if user := GlobalUserPool.GetUser(userID); user != nil {
 					delete(user.PieceCount, id)
 				}
 			}
 			delete(p.puzzles, id)
+			puzzle.Stop()
 		}
 	}
 	imageFolder, err := os.Open("images")
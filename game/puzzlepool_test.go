@@ -0,0 +1,49 @@
+package game
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestPuzzlePool creates a puzzle pool with empty stores
+func newTestPuzzlePool(t *testing.T) *PuzzlePool {
+	t.Helper()
+	dir := t.TempDir()
+	puzzles, err := NewFilePuzzleStore(filepath.Join(dir, "puzzles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := NewFileEventStore(filepath.Join(dir, "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := NewFileResultStore(filepath.Join(dir, "results"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPuzzlePool(puzzles, events, results)
+}
+
+func TestReserve(t *testing.T) {
+	pool := newTestPuzzlePool(t)
+	if err := pool.Reserve("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Reserve("test"); CodeOf(err) != ErrPuzzleExists {
+		t.Fatalf("reserving a reserved id failed with %v, want %s", err, ErrPuzzleExists)
+	}
+	pool.Release("test")
+	if err := pool.Reserve("test"); err != nil {
+		t.Fatalf("reserving a released id failed with %v", err)
+	}
+
+	p := newTestLivePuzzle(t, Options{}, newTestUsers())
+	if err := pool.AddPuzzle(p); err != nil {
+		t.Fatal(err)
+	}
+	// the puzzle took over the reservation, so releasing it changes nothing
+	pool.Release("test")
+	if err := pool.Reserve("test"); CodeOf(err) != ErrPuzzleExists {
+		t.Fatalf("reserving a live puzzle's id failed with %v, want %s", err, ErrPuzzleExists)
+	}
+}
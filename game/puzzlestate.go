@@ -0,0 +1,192 @@
+package game
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/ilikerice123/puzzle/picture"
+	"github.com/ilikerice123/puzzle/store"
+)
+
+// PuzzleState is everything needed to restore a puzzle, unlike the puzzle's
+// json which hides where pieces belong
+type PuzzleState struct {
+	ID            string           `json:"id"`
+	YSize         int              `json:"ySize"`
+	XSize         int              `json:"xSize"`
+	Options       Options          `json:"options"`
+	ImageWidth    int              `json:"imageWidth"`
+	ImageHeight   int              `json:"imageHeight"`
+	PieceMargin   int              `json:"pieceMargin"`
+	PiecesCorrect int              `json:"piecesCorrect"`
+	NextUpdateID  int              `json:"nextUpdateID"`
+	LastUpdated   time.Time        `json:"lastUpdated"`
+	Pieces        []PieceState     `json:"pieces"`
+	Scores        map[string]int   `json:"scores"`
+	Teams         map[string]*Team `json:"teams,omitempty"`
+	Clock         *Clock           `json:"clock,omitempty"`
+	Streaks       map[string]int   `json:"streaks,omitempty"`
+	Hints         map[string]int   `json:"hints,omitempty"`
+}
+
+// PieceState is everything needed to restore a piece. Pieces are stored in
+// order of id, and where they belong is derived from that. Placed is whether
+// the piece was ever put where it belongs
+type PieceState struct {
+	CurrPos   Position       `json:"currPos"`
+	BoardPos  *Point         `json:"boardPos,omitempty"`
+	ImageFile string         `json:"image"`
+	Edges     *picture.Edges `json:"edges,omitempty"`
+	Rotation  int            `json:"rotation"`
+	Placed    bool           `json:"placed,omitempty"`
+	Locked    bool           `json:"locked,omitempty"`
+}
+
+// State returns the state of the puzzle, without who is currently playing it
+func (p *Puzzle) State() *PuzzleState {
+	clock := *p.Clock
+	state := &PuzzleState{
+		ID:            p.ID,
+		YSize:         p.YSize,
+		XSize:         p.XSize,
+		Options:       p.options,
+		ImageWidth:    p.ImageWidth,
+		ImageHeight:   p.ImageHeight,
+		PieceMargin:   p.PieceMargin,
+		PiecesCorrect: p.PiecesCorrect,
+		NextUpdateID:  p.NextUpdateID,
+		LastUpdated:   p.LastUpdated,
+		Pieces:        make([]PieceState, len(p.byID)),
+		Scores:        make(map[string]int),
+		Teams:         copyTeams(p.Teams),
+		Clock:         &clock,
+		Streaks:       make(map[string]int),
+		Hints:         make(map[string]int)}
+	for i, piece := range p.byID {
+		state.Pieces[i] = PieceState{
+			CurrPos:   piece.CurrPos,
+			ImageFile: piece.ImageFile,
+			Edges:     piece.Edges,
+			Rotation:  piece.Rotation,
+			Placed:    p.placed[piece.ID],
+			Locked:    piece.Locked}
+		if piece.BoardPos != nil {
+			pt := *piece.BoardPos
+			state.Pieces[i].BoardPos = &pt
+		}
+	}
+	for userID, score := range p.scores {
+		state.Scores[userID] = score
+	}
+	for userID, streak := range p.streaks {
+		state.Streaks[userID] = streak
+	}
+	for userID, used := range p.hintsUsed {
+		state.Hints[userID] = used
+	}
+	return state
+}
+
+// RestorePuzzle creates a puzzle from the state it was saved in. Nobody is
+// playing it, or holding any pieces, until they join again, and a clock that
+// was running waits for the first of them
+func RestorePuzzle(
+	state *PuzzleState,
+	updatesChannel chan<- *Update,
+	users UserPoolBase) *Puzzle {
+	puzzle := Puzzle{
+		ID:            state.ID,
+		Pieces:        make([][]*Piece, state.YSize),
+		HeldPieces:    make(map[string]*Piece),
+		Size:          state.YSize * state.XSize,
+		PiecesCorrect: state.PiecesCorrect,
+		NextUpdateID:  state.NextUpdateID,
+		LastUpdated:   state.LastUpdated,
+		CurrentUsers:  make(map[string]*store.User),
+		Teams:         copyTeams(state.Teams),
+		teamOf:        make(map[string]string),
+		Clock:         &Clock{State: ClockWaiting, TimeLimit: state.Options.TimeLimit},
+		updates:       updatesChannel,
+		users:         users,
+		XSize:         state.XSize,
+		YSize:         state.YSize,
+		ImageWidth:    state.ImageWidth,
+		ImageHeight:   state.ImageHeight,
+		Mode:          state.Options.Mode,
+		PieceMargin:   state.PieceMargin,
+		Seed:          state.Options.Seed,
+		Scoring:       state.Options.Scoring,
+		LockCorrect:   state.Options.LockCorrect,
+		Host:          state.Options.Host,
+		Layout:        state.Options.Layout,
+		options:       state.Options,
+		topology:      newTopology(state.Options.Layout, state.YSize, state.XSize),
+		policy:        newScoringPolicy(state.Options.Scoring),
+		rng:           rand.New(rand.NewSource(state.Options.Seed)),
+		byID:          make([]*Piece, len(state.Pieces)),
+		correct:       make([]bool, len(state.Pieces)),
+		placed:        make([]bool, len(state.Pieces)),
+		streaks:       make(map[string]int),
+		hintsUsed:     make(map[string]int),
+		lastSwaps:     make(map[string]*swapRecord),
+		touched:       make([]int, len(state.Pieces)),
+		scores:        make(map[string]int),
+	}
+	if puzzle.Mode == FreeMode {
+		puzzle.Table = newTable(puzzle.YSize, puzzle.XSize)
+	}
+
+	for i := range puzzle.Pieces {
+		puzzle.Pieces[i] = make([]*Piece, puzzle.XSize)
+	}
+	for id, pieceState := range state.Pieces {
+		piece := &Piece{
+			DestPos:   Position{Y: id / puzzle.XSize, X: id % puzzle.XSize},
+			CurrPos:   pieceState.CurrPos,
+			BoardPos:  pieceState.BoardPos,
+			ID:        id,
+			ImageFile: pieceState.ImageFile,
+			Edges:     pieceState.Edges,
+			Rotation:  pieceState.Rotation,
+			Locked:    pieceState.Locked}
+		puzzle.Pieces[piece.CurrPos.Y][piece.CurrPos.X] = piece
+		puzzle.byID[id] = piece
+		puzzle.correct[id] = piece.Correct()
+		puzzle.placed[id] = pieceState.Placed || piece.Correct()
+	}
+	for userID, streak := range state.Streaks {
+		puzzle.streaks[userID] = streak
+	}
+	for userID, used := range state.Hints {
+		puzzle.hintsUsed[userID] = used
+	}
+	for userID, score := range state.Scores {
+		puzzle.scores[userID] = score
+	}
+	if state.Clock != nil {
+		clock := *state.Clock
+		if clock.State == ClockRunning {
+			// the clock doesn't run while the server is down, it starts again
+			// when the first user joins
+			clock.State = ClockWaiting
+		}
+		puzzle.Clock = &clock
+	}
+	for name, team := range puzzle.Teams {
+		for _, userID := range team.Members {
+			puzzle.teamOf[userID] = name
+		}
+	}
+	puzzle.regroup()
+
+	return &puzzle
+}
+
+// disconnectAll removes every user from the puzzle without sending updates,
+// like when the puzzle is restored, keeping their scores
+func (p *Puzzle) disconnectAll() {
+	for userID := range p.CurrentUsers {
+		p.release(userID)
+	}
+	p.CurrentUsers = make(map[string]*store.User)
+}
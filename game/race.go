@@ -0,0 +1,285 @@
+package game
+
+import (
+	"time"
+)
+
+// RacePuzzle is a puzzle where every player, or every team, races on their own
+// board, and implements PuzzleBase. Boards are puzzles shuffled the same way,
+// and their updates are renumbered so the race has a single sequence of
+// updates. Boards of players are named "user:" followed by the player's id,
+// and boards of teams "team:" followed by the team's name, so a player can't
+// share a name with a team
+type RacePuzzle struct {
+	ID           string             `json:"id"`
+	Race         bool               `json:"race"`
+	Boards       map[string]*Puzzle `json:"boards"`
+	NextUpdateID int                `json:"nextUpdateID"`
+	Started      time.Time          `json:"started"`
+	TimeLimit    int                `json:"timeLimit,omitempty"`
+	Winner       string             `json:"winner,omitempty"`
+	Finished     bool               `json:"finished"`
+	// created is when the race was created, Started is zero until the first
+	// player joins
+	created time.Time
+	// finishedAt is when the race finished
+	finishedAt time.Time
+	// template is the board every player starts with, it is never played
+	template *Puzzle
+	// players is the name of the board of every player
+	players map[string]string
+	updates chan<- *Update
+	users   UserPoolBase
+	emitted []*Update
+}
+
+// NewRacePuzzle creates a race for the image in file. Every board is cut and
+// shuffled the same way, according to options. The time limit is for the
+// whole race rather than for each board, and counts from when the first player
+// joins
+func NewRacePuzzle(
+	id string,
+	file string,
+	ySize int,
+	xSize int,
+	options Options,
+	updatesChannel chan<- *Update,
+	users UserPoolBase) *RacePuzzle {
+	boardOptions := options
+	boardOptions.TimeLimit = 0
+	template := NewPuzzle(id, file, ySize, xSize, boardOptions, nil, users)
+	if template == nil {
+		return nil
+	}
+	return &RacePuzzle{
+		ID:        id,
+		Race:      true,
+		Boards:    make(map[string]*Puzzle),
+		TimeLimit: options.TimeLimit,
+		created:   time.Now(),
+		template:  template,
+		players:   make(map[string]string),
+		updates:   updatesChannel,
+		users:     users}
+}
+
+// NewRaceLivePuzzle creates a new live race
+func NewRaceLivePuzzle(
+	id string,
+	file string,
+	ySize int,
+	xSize int,
+	options Options,
+	users UserPoolBase) *LivePuzzle {
+	updates := make(chan *Update)
+	race := NewRacePuzzle(id, file, ySize, xSize, options, updates, users)
+	if race == nil {
+		return nil
+	}
+	return newLivePuzzle(race, updates, -1)
+}
+
+// GetID returns id of the race for the interface
+func (r *RacePuzzle) GetID() string {
+	return r.ID
+}
+
+// Do does the request on the board of the user that sent it, and returns the
+// updates it caused. Users are put on a board when they first join, the board
+// of their team if they join one
+func (r *RacePuzzle) Do(req Request) ([]*Update, error) {
+	r.emitted = nil
+	err := r.do(req)
+	return r.emitted, err
+}
+
+// do does the request on the race
+func (r *RacePuzzle) do(req Request) error {
+	if req.Action == TICK {
+		if !req.internal {
+			return newError(ErrUnknownAction, "unknown action")
+		}
+		limit := time.Duration(r.TimeLimit) * time.Second
+		if !r.Finished && !r.Started.IsZero() && r.TimeLimit > 0 && requestTime(req).Sub(r.Started) >= limit {
+			r.finish(r.leader(), requestTime(req))
+		}
+		return nil
+	}
+	if (req.Action == JOIN || req.Action == LEAVE) && !req.internal {
+		return newError(ErrUnknownAction, "unknown action")
+	}
+	boardID, playing := r.players[req.UserID]
+	if r.Finished && (!playing || req.Action != JOIN && req.Action != LEAVE) {
+		return newError(ErrPuzzleComplete, "race finished")
+	}
+	if !playing {
+		if req.Action != JOIN {
+			return newError(ErrNotJoined, "user isn't playing on any board")
+		}
+		if r.users.GetUser(req.UserID) == nil {
+			return newError(ErrUnknownUser, "user not registered in pool")
+		}
+		if err := validTeam(req.Team); err != nil {
+			return err
+		}
+		boardID = userBoard(req.UserID)
+		if req.Team != "" {
+			boardID = teamBoard(req.Team)
+		}
+	} else if req.Action == JOIN && req.Team != "" && teamBoard(req.Team) != boardID {
+		return newError(ErrInvalidTeam, "user already plays on another board")
+	}
+	board, exists := r.Boards[boardID]
+	if !exists {
+		board = RestorePuzzle(r.template.State(), nil, r.users)
+		r.Boards[boardID] = board
+	}
+
+	updates, err := board.Do(req)
+	if err == nil && req.Action == JOIN {
+		r.players[req.UserID] = boardID
+		if r.Started.IsZero() {
+			r.Started = requestTime(req)
+		}
+	}
+	for _, update := range updates {
+		update.ID = r.NextUpdateID
+		update.Board = boardID
+		// the final results of a board are part of the race's
+		update.Final = nil
+		r.NextUpdateID++
+		r.emit(update)
+	}
+	if err == nil && !r.Finished && board.Complete() {
+		r.finish(boardID, requestTime(req))
+	}
+	return err
+}
+
+// userBoard returns the name of the board of a player that isn't on a team
+func userBoard(userID string) string {
+	return "user:" + userID
+}
+
+// teamBoard returns the name of the board of a team
+func teamBoard(name string) string {
+	return "team:" + name
+}
+
+// finish ends the race at now, with winner as the winning board, or no winner
+// if it is empty
+func (r *RacePuzzle) finish(winner string, now time.Time) {
+	r.Finished = true
+	r.Winner = winner
+	r.finishedAt = now
+	update := &Update{ID: r.NextUpdateID, Action: FINISH, Board: winner, Scores: r.scores()}
+	update.Final = newFinalResults(r.ID, now, r.Results(), r.teamOf(), r.users)
+	update.Final.Winner = winner
+	r.NextUpdateID++
+	r.emit(update)
+}
+
+// teamOf returns the team of every user on one, on any board
+func (r *RacePuzzle) teamOf() map[string]string {
+	teamOf := make(map[string]string)
+	for _, board := range r.Boards {
+		for userID, name := range board.teamOf {
+			teamOf[userID] = name
+		}
+	}
+	return teamOf
+}
+
+// leader returns the board with the most pieces correct, or an empty string
+// if boards are tied for the most
+func (r *RacePuzzle) leader() string {
+	leader, most, tied := "", -1, false
+	for boardID, board := range r.Boards {
+		if board.PiecesCorrect > most {
+			leader, most, tied = boardID, board.PiecesCorrect, false
+		} else if board.PiecesCorrect == most {
+			tied = true
+		}
+	}
+	if tied {
+		return ""
+	}
+	return leader
+}
+
+// Shuffle shuffles every board
+func (r *RacePuzzle) Shuffle() {
+	for _, board := range r.Boards {
+		board.Shuffle()
+	}
+}
+
+// OnComplete does nothing, races are over once they finish rather than when a
+// board is complete
+func (r *RacePuzzle) OnComplete() {
+
+}
+
+// Results returns the results of every board together, and how many seconds
+// the race has been going for, which is 0 until the first player joins
+func (r *RacePuzzle) Results() Results {
+	end := time.Now()
+	if r.Finished {
+		end = r.finishedAt
+	}
+	results := Results{
+		Users: make(map[string]int),
+		Teams: make(map[string]int)}
+	if !r.Started.IsZero() {
+		results.Elapsed = end.Sub(r.Started).Seconds()
+	}
+	for _, board := range r.Boards {
+		boardResults := board.Results()
+		for userID, score := range boardResults.Users {
+			results.Users[userID] = score
+		}
+		for name, score := range boardResults.Teams {
+			results.Teams[name] = score
+		}
+	}
+	return results
+}
+
+// scores returns how many pieces are correct on each board
+func (r *RacePuzzle) scores() map[string]int {
+	scores := make(map[string]int)
+	for boardID, board := range r.Boards {
+		scores[boardID] = board.PiecesCorrect
+	}
+	return scores
+}
+
+// Complete returns if the race is over
+func (r *RacePuzzle) Complete() bool {
+	return r.Finished
+}
+
+// LastUpdatedTime returns when a board of the race was last updated, or when
+// the race was created if no board was
+func (r *RacePuzzle) LastUpdatedTime() time.Time {
+	last := r.created
+	for _, board := range r.Boards {
+		if board.LastUpdated.After(last) {
+			last = board.LastUpdated
+		}
+	}
+	return last
+}
+
+// State returns nil, races aren't saved
+func (r *RacePuzzle) State() *PuzzleState {
+	return nil
+}
+
+// emit sends an update out, and records it as caused by the current request
+func (r *RacePuzzle) emit(u *Update) {
+	r.emitted = append(r.emitted, u)
+	if r.updates != nil {
+		r.updates <- u
+	}
+}
@@ -0,0 +1,77 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestRace creates a race on 2*2 boards that are one swap, of the pieces at
+// (0, 0) and (0, 1), away from complete, which doesn't send its updates
+// anywhere
+func newTestRace(users UserPoolBase) *RacePuzzle {
+	template := newTestPuzzle(2, 2, Options{}, users)
+	arrange(template, [2]Position{{Y: 0, X: 0}, {Y: 0, X: 1}})
+	return &RacePuzzle{
+		ID:       "test",
+		Race:     true,
+		Boards:   make(map[string]*Puzzle),
+		created:  time.Now(),
+		template: template,
+		players:  make(map[string]string),
+		users:    users}
+}
+
+func TestRaceBoards(t *testing.T) {
+	r := newTestRace(newTestUsers("u1", "u2", "u3"))
+	if _, err := r.Do(Request{Action: JOIN, UserID: "u1"}); CodeOf(err) != ErrUnknownAction {
+		t.Fatalf("client sent JOIN failed with %v, want %s", err, ErrUnknownAction)
+	}
+	if len(r.Boards) != 0 {
+		t.Fatalf("client sent JOIN made %d boards", len(r.Boards))
+	}
+
+	do(t, r, Request{Action: JOIN, UserID: "u1", internal: true})
+	do(t, r, Request{Action: JOIN, UserID: "u2", Team: "red", internal: true})
+	updates := do(t, r, Request{Action: JOIN, UserID: "u3", Team: "red", internal: true})
+	if len(r.Boards) != 2 || r.Boards["user:u1"] == nil || r.Boards["team:red"] == nil {
+		t.Fatalf("players joined boards %v, want user:u1 and team:red", r.Boards)
+	}
+	if updates[0].Board != "team:red" || updates[0].ID != r.NextUpdateID-len(updates) {
+		t.Errorf("JOIN update is on board %q with id %d", updates[0].Board, updates[0].ID)
+	}
+	if _, err := r.Do(Request{Action: JOIN, UserID: "u1", Team: "red", internal: true}); CodeOf(err) != ErrInvalidTeam {
+		t.Errorf("switching boards failed with %v, want %s", err, ErrInvalidTeam)
+	}
+	// a team's players share their board
+	do(t, r, Request{Action: HOLD, UserID: "u2", PiecePos: Position{Y: 1, X: 1}})
+	if held := r.Boards["team:red"].HeldPieces["u2"]; held == nil {
+		t.Errorf("u2 isn't holding a piece on their team's board")
+	}
+	if len(r.Boards["user:u1"].HeldPieces) != 0 {
+		t.Errorf("u2 held a piece on u1's board")
+	}
+}
+
+func TestRaceFinishes(t *testing.T) {
+	r := newTestRace(newTestUsers("u1", "u2"))
+	do(t, r, Request{Action: JOIN, UserID: "u1", internal: true})
+	do(t, r, Request{Action: JOIN, UserID: "u2", internal: true})
+	if _, err := r.Do(Request{Action: HOLD, UserID: "u3", PiecePos: Position{}}); CodeOf(err) != ErrNotJoined {
+		t.Errorf("moving without playing failed with %v, want %s", err, ErrNotJoined)
+	}
+
+	do(t, r, Request{Action: HOLD, UserID: "u1", PiecePos: Position{Y: 0, X: 0}})
+	updates := do(t, r, Request{Action: HOLD, UserID: "u1", PiecePos: Position{Y: 0, X: 1}})
+	finish := updates[len(updates)-1]
+	if !r.Complete() || r.Winner != "user:u1" || finish.Action != FINISH || finish.Final.Winner != "user:u1" {
+		t.Fatalf("race finished %t with winner %q, last update %+v", r.Complete(), r.Winner, finish)
+	}
+	if finish.Scores["user:u1"] != 4 || finish.Scores["user:u2"] != 2 {
+		t.Errorf("FINISH scores are %v", finish.Scores)
+	}
+	if _, err := r.Do(Request{Action: HOLD, UserID: "u2", PiecePos: Position{}}); CodeOf(err) != ErrPuzzleComplete {
+		t.Errorf("moving after the race finished failed with %v, want %s", err, ErrPuzzleComplete)
+	}
+	// players can still leave
+	do(t, r, Request{Action: LEAVE, UserID: "u2", internal: true})
+}
@@ -0,0 +1,190 @@
+package game
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Standing is where a user or a team placed once a puzzle was complete. Users
+// or teams with the same score share a rank
+type Standing struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Team  string `json:"team,omitempty"`
+	Score int    `json:"score"`
+	Rank  int    `json:"rank"`
+}
+
+// FinalResults are the standings of every user that played a puzzle, and of
+// every team, when it was completed, and how many seconds it took. Winner is
+// the winning board of a race
+type FinalResults struct {
+	PuzzleID  string     `json:"puzzleID"`
+	Completed time.Time  `json:"completed"`
+	Duration  float64    `json:"duration"`
+	Winner    string     `json:"winner,omitempty"`
+	Users     []Standing `json:"users"`
+	Teams     []Standing `json:"teams,omitempty"`
+}
+
+// UserResult is how a user placed in a puzzle they played, out of how many
+// players
+type UserResult struct {
+	PuzzleID  string    `json:"puzzleID"`
+	Completed time.Time `json:"completed"`
+	Team      string    `json:"team,omitempty"`
+	Score     int       `json:"score"`
+	Rank      int       `json:"rank"`
+	Players   int       `json:"players"`
+}
+
+// newFinalResults ranks the users and teams in results. teamOf is the team of
+// every user on one
+func newFinalResults(
+	id string,
+	completed time.Time,
+	results Results,
+	teamOf map[string]string,
+	users UserPoolBase) *FinalResults {
+	final := &FinalResults{
+		PuzzleID:  id,
+		Completed: completed,
+		Duration:  results.Elapsed,
+		Users:     rank(results.Users)}
+	for i := range final.Users {
+		standing := &final.Users[i]
+		standing.Team = teamOf[standing.ID]
+		if user := users.GetUser(standing.ID); user != nil {
+			standing.Name = user.Name
+		}
+	}
+	if len(results.Teams) > 0 {
+		final.Teams = rank(results.Teams)
+	}
+	return final
+}
+
+// rank returns standings from highest to lowest score, ties broken by id
+func rank(scores map[string]int) []Standing {
+	standings := make([]Standing, 0, len(scores))
+	for id, score := range scores {
+		standings = append(standings, Standing{ID: id, Score: score})
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		return standings[i].ID < standings[j].ID
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && standings[i].Score == standings[i-1].Score {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
+
+// ResultStore archives the final results of complete puzzles, so they outlive
+// the puzzles
+type ResultStore interface {
+	SaveResults(*FinalResults) error
+
+	LoadResults(id string) (*FinalResults, error)
+
+	UserResults(userID string) ([]*UserResult, error)
+}
+
+// FileResultStore implements ResultStore by saving the results of each puzzle
+// as a json file in a directory, and the results of each user as a file of
+// json lines in its users directory
+type FileResultStore struct {
+	dir string
+}
+
+// NewFileResultStore creates a result store in dir, creating it if necessary
+func NewFileResultStore(dir string) (*FileResultStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "users"), 0755); err != nil {
+		return nil, err
+	}
+	return &FileResultStore{dir: dir}, nil
+}
+
+// SaveResults saves the final results of a puzzle, and adds them to the
+// results of every user that played it
+func (s *FileResultStore) SaveResults(final *FinalResults) error {
+	serialized, err := json.Marshal(final)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path(final.PuzzleID), serialized); err != nil {
+		return err
+	}
+
+	for _, standing := range final.Users {
+		result := &UserResult{
+			PuzzleID:  final.PuzzleID,
+			Completed: final.Completed,
+			Team:      standing.Team,
+			Score:     standing.Score,
+			Rank:      standing.Rank,
+			Players:   len(final.Users)}
+		if err := s.appendUserResult(standing.ID, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadResults returns the final results of a puzzle, or nil if they weren't
+// saved
+func (s *FileResultStore) LoadResults(id string) (*FinalResults, error) {
+	serialized, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var final FinalResults
+	if err := json.Unmarshal(serialized, &final); err != nil {
+		return nil, err
+	}
+	return &final, nil
+}
+
+// UserResults returns the results of every complete puzzle a user played, in
+// the order they were completed
+func (s *FileResultStore) UserResults(userID string) ([]*UserResult, error) {
+	results := make([]*UserResult, 0)
+	err := readJSONLines(s.userPath(userID), func(line []byte) error {
+		var result UserResult
+		if err := json.Unmarshal(line, &result); err != nil {
+			return err
+		}
+		results = append(results, &result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// appendUserResult adds a result to the results of a user
+func (s *FileResultStore) appendUserResult(userID string, result *UserResult) error {
+	return appendJSONLine(s.userPath(userID), result)
+}
+
+// path returns the file the results of a puzzle are saved in
+func (s *FileResultStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// userPath returns the file the results of a user are saved in
+func (s *FileResultStore) userPath(userID string) string {
+	return filepath.Join(s.dir, "users", userID+".log")
+}
@@ -0,0 +1,60 @@
+package game
+
+import (
+	"time"
+)
+
+// randomizeRotations turns every piece to a random orientation, except for
+// pieces the shuffle left where they belong
+func (p *Puzzle) randomizeRotations() {
+	for _, piece := range p.byID {
+		if !piece.Correct() {
+			piece.Rotation = p.rng.Intn(4)
+		}
+	}
+}
+
+// rotate turns a piece a quarter turn clockwise. Pieces that are joined to
+// other pieces can't be rotated
+func (p *Puzzle) rotate(r Request) error {
+	if !p.options.Rotation {
+		return newError(ErrWrongMode, "pieces can only be rotated in rotation mode")
+	}
+	if !p.inBounds(r.PiecePos) {
+		return newError(ErrOutOfBounds, "piece x and y out of bounds")
+	}
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+
+	piece := p.Pieces[r.PiecePos.Y][r.PiecePos.X]
+	if piece.HeldBy != "" && piece.HeldBy != r.UserID {
+		return newError(ErrPieceHeld, "piece is held by another user")
+	}
+	if len(p.group(piece)) > 1 {
+		return newError(ErrInGroup, "piece is joined to other pieces")
+	}
+	if piece.Locked {
+		return newError(ErrPieceLocked, "piece is locked")
+	}
+
+	p.LastUpdated = time.Now()
+	delta := 0
+	if piece.Correct() {
+		delta--
+	}
+	piece.Rotation = (piece.Rotation + 1) % 4
+	if piece.Correct() {
+		delta++
+	}
+	points := p.score(r.UserID, delta, []*Piece{piece})
+	sizes := p.groupSizes([]*Piece{piece})
+	p.regroup()
+
+	update := p.newUpdate(ROTATE, r.UserID, piece.CurrPos, Position{}, delta)
+	update.Points = points
+	update.Rotation = piece.Rotation
+	p.emit(update)
+	p.emitMerges(r.UserID, sizes)
+	return nil
+}
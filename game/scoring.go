@@ -0,0 +1,169 @@
+package game
+
+// Ruleset configures how many points users score for moving pieces
+// - Placement is what putting a piece where it belongs is worth, and Edge and
+//   Corner are what pieces on the border and in the corners are worth
+//   instead, unless they are 0
+// - Penalty is what moving a piece out of place costs
+// - if FirstOnly is set, pieces are only worth points the first time they are
+//   put where they belong, so they can't be farmed by moving them out and back
+// - Streak is the most points are multiplied by for moves in a row that put
+//   pieces where they belong, where moving a piece out of place ends a streak.
+//   0 or 1 turns streaks off
+type Ruleset struct {
+	Placement int  `json:"placement"`
+	Edge      int  `json:"edge,omitempty"`
+	Corner    int  `json:"corner,omitempty"`
+	Penalty   int  `json:"penalty"`
+	FirstOnly bool `json:"firstOnly,omitempty"`
+	Streak    int  `json:"streak,omitempty"`
+}
+
+// Validate checks that the ruleset is valid
+func (r *Ruleset) Validate() error {
+	if r.Placement < 0 || r.Edge < 0 || r.Corner < 0 || r.Penalty < 0 || r.Streak < 0 {
+		return newError(ErrInvalidOptions, "scoring can't be negative")
+	}
+	return nil
+}
+
+// ScoredMove is a move of a user being scored. Placed are the pieces it put
+// where they belong, and first says which of them were put there for the
+// first time. Displaced are the pieces it moved out of place. Streak is how
+// many moves in a row the user put pieces where they belong, including this
+// one
+type ScoredMove struct {
+	UserID    string
+	Placed    []*Piece
+	First     []bool
+	Displaced []*Piece
+	Streak    int
+}
+
+// ScoringPolicy decides how many points a move is worth
+type ScoringPolicy interface {
+	Points(p *Puzzle, m *ScoredMove) int
+
+	// Rules returns the ruleset of the policy, or nil for the classic policy
+	Rules() *Ruleset
+}
+
+// newScoringPolicy returns the policy for a ruleset, or the classic policy if
+// it is nil
+func newScoringPolicy(rules *Ruleset) ScoringPolicy {
+	if rules == nil {
+		return classicScoring{}
+	}
+	return &ruleScoring{rules: *rules}
+}
+
+// classicScoring gives a point for every piece put where it belongs, and
+// takes one for every piece moved out of place
+type classicScoring struct{}
+
+// Points returns how many pieces the move put in place, less the ones it moved
+// out of place
+func (classicScoring) Points(p *Puzzle, m *ScoredMove) int {
+	return len(m.Placed) - len(m.Displaced)
+}
+
+// Rules returns nil, the classic policy doesn't have a ruleset
+func (classicScoring) Rules() *Ruleset {
+	return nil
+}
+
+// ruleScoring scores moves according to a ruleset
+type ruleScoring struct {
+	rules Ruleset
+}
+
+// Points returns what the pieces the move put in place are worth, multiplied
+// by its streak, less the penalty for the ones it moved out of place
+func (s *ruleScoring) Points(p *Puzzle, m *ScoredMove) int {
+	points := 0
+	for i, piece := range m.Placed {
+		if s.rules.FirstOnly && !m.First[i] {
+			continue
+		}
+		points += s.worth(p, piece)
+	}
+	if s.rules.Streak > 1 && m.Streak > 1 {
+		multiplier := m.Streak
+		if multiplier > s.rules.Streak {
+			multiplier = s.rules.Streak
+		}
+		points *= multiplier
+	}
+	return points - s.rules.Penalty*len(m.Displaced)
+}
+
+// Rules returns the ruleset
+func (s *ruleScoring) Rules() *Ruleset {
+	rules := s.rules
+	return &rules
+}
+
+// worth returns what putting a piece where it belongs is worth
+func (s *ruleScoring) worth(p *Puzzle, piece *Piece) int {
+	if s.rules.Corner != 0 && p.topology.Corner(piece.DestPos) {
+		return s.rules.Corner
+	}
+	if s.rules.Edge != 0 && p.topology.Border(piece.DestPos) {
+		return s.rules.Edge
+	}
+	return s.rules.Placement
+}
+
+// score updates how many pieces are correct after a move by a user, which
+// changed the correct pieces of delta, and scores the move for the user and
+// their team. pieces are every piece the move moved. Returns the points the
+// move was worth
+func (p *Puzzle) score(userID string, delta int, pieces []*Piece) int {
+	p.PiecesCorrect += delta
+	p.moves++
+	// only the last move of a user can be undone, and only if it was a swap,
+	// which records how to undo it after being scored
+	p.lastSwaps[userID] = nil
+	m := &ScoredMove{UserID: userID}
+	for _, piece := range pieces {
+		p.touched[piece.ID] = p.moves
+		correct := piece.Correct()
+		if correct == p.correct[piece.ID] {
+			continue
+		}
+		p.correct[piece.ID] = correct
+		if correct {
+			m.Placed = append(m.Placed, piece)
+			m.First = append(m.First, !p.placed[piece.ID])
+			p.placed[piece.ID] = true
+			p.lock(piece)
+		} else {
+			m.Displaced = append(m.Displaced, piece)
+			piece.Locked = false
+		}
+	}
+	if len(m.Displaced) > 0 {
+		p.streaks[userID] = 0
+	} else if len(m.Placed) > 0 {
+		p.streaks[userID]++
+	}
+	m.Streak = p.streaks[userID]
+
+	points := p.policy.Points(p, m)
+	if user, exists := p.CurrentUsers[userID]; exists {
+		user.LifetimePieces += delta
+	}
+	p.award(userID, points)
+	return points
+}
+
+// award adds points to the score of a user, and of their team
+func (p *Puzzle) award(userID string, points int) {
+	if user, exists := p.CurrentUsers[userID]; exists {
+		user.PieceCount[p.ID] += points
+		p.scores[userID] = user.PieceCount[p.ID]
+	}
+	if name, exists := p.teamOf[userID]; exists {
+		p.Teams[name].Score += points
+	}
+}
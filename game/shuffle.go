@@ -0,0 +1,131 @@
+package game
+
+// Shuffle shuffles the puzzle with the shuffle it was created with, and counts
+// how many pieces are left correct. If the shuffle can't move any piece on a
+// board this small, every piece is deranged instead. In free mode, pieces are
+// then scattered across the table, or placed in the cell they were shuffled to
+// if the shuffle keeps them near where they belong. Randomness comes from the
+// puzzle's rng
+func (p *Puzzle) Shuffle() {
+	switch p.options.Shuffle {
+	case PartialShuffle:
+		p.partialShuffle(p.options.InPlace)
+	case LocalShuffle:
+		p.localShuffle(p.options.Distance)
+	case RowShuffle:
+		for i := range p.Pieces {
+			p.derange(p.cells(func(pos Position) bool { return pos.Y == i }))
+		}
+	case ColumnShuffle:
+		for j := 0; j < p.XSize; j++ {
+			p.derange(p.cells(func(pos Position) bool { return pos.X == j }))
+		}
+	case EdgesSolvedShuffle:
+		p.derange(p.cells(func(pos Position) bool { return !p.topology.Border(pos) }))
+	default:
+		p.derange(p.cells(func(Position) bool { return true }))
+	}
+	p.countCorrect()
+	if p.Complete() {
+		p.derange(p.cells(func(Position) bool { return true }))
+		p.countCorrect()
+	}
+
+	if p.Mode == FreeMode {
+		if p.options.Shuffle == DerangeShuffle {
+			p.scatter()
+		} else {
+			for _, piece := range p.byID {
+				pt := piece.CurrPos.Point()
+				piece.BoardPos = &pt
+			}
+		}
+	}
+}
+
+// derange shuffles the pieces in cells based on Fisher–Yates shuffle,
+// modified so none of them are left in the cell they started in. Pieces are
+// only shuffled between cells of the same shape
+func (p *Puzzle) derange(cells []Position) {
+	for len(cells) > 0 {
+		same, rest := cells[:0:0], cells[:0:0]
+		for _, pos := range cells {
+			if p.topology.SameShape(cells[0], pos) {
+				same = append(same, pos)
+			} else {
+				rest = append(rest, pos)
+			}
+		}
+		for i := 0; i < len(same)-1; i++ {
+			j := p.rng.Intn(len(same)-i-1) + i + 1
+			p.swap(p.Pieces[same[i].Y][same[i].X], p.Pieces[same[j].Y][same[j].X])
+		}
+		cells = rest
+	}
+}
+
+// partialShuffle leaves inPlace percent of the pieces where they are, and
+// deranges the rest
+func (p *Puzzle) partialShuffle(inPlace int) {
+	shuffled := p.Size - p.Size*inPlace/100
+	if shuffled == 1 && p.Size > 1 {
+		// a piece can't be shuffled on its own
+		shuffled = 2
+	}
+	all := p.cells(func(Position) bool { return true })
+	cells := make([]Position, 0, shuffled)
+	for _, idx := range p.rng.Perm(p.Size)[:shuffled] {
+		cells = append(cells, all[idx])
+	}
+	p.derange(cells)
+}
+
+// localShuffle splits the grid into blocks of distance+1 cells across and
+// down, at a random offset, and deranges each block, so every piece stays
+// within distance cells of where it started
+func (p *Puzzle) localShuffle(distance int) {
+	size := distance + 1
+	offsetY, offsetX := p.rng.Intn(size), p.rng.Intn(size)
+	var blocks []Position
+	cells := make(map[Position][]Position)
+	for _, pos := range p.cells(func(Position) bool { return true }) {
+		block := Position{Y: (pos.Y + offsetY) / size, X: (pos.X + offsetX) / size}
+		if _, exists := cells[block]; !exists {
+			blocks = append(blocks, block)
+		}
+		cells[block] = append(cells[block], pos)
+	}
+	for _, block := range blocks {
+		p.derange(cells[block])
+	}
+}
+
+// cells returns the positions of the grid that include returns true for, row
+// by row
+func (p *Puzzle) cells(include func(Position) bool) []Position {
+	var cells []Position
+	for i := range p.Pieces {
+		for j := range p.Pieces[i] {
+			pos := Position{Y: i, X: j}
+			if include(pos) {
+				cells = append(cells, pos)
+			}
+		}
+	}
+	return cells
+}
+
+// countCorrect recounts how many pieces are correct. Pieces that are correct
+// count as placed, so they aren't worth points until they are moved, and are
+// locked if correct pieces are locked
+func (p *Puzzle) countCorrect() {
+	p.PiecesCorrect = 0
+	for _, piece := range p.byID {
+		p.correct[piece.ID] = piece.Correct()
+		piece.Locked = p.options.LockCorrect && piece.Correct()
+		if piece.Correct() {
+			p.PiecesCorrect++
+			p.placed[piece.ID] = true
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+// currPositions returns where every piece of a puzzle is, by id
+func currPositions(p *Puzzle) []Position {
+	pos := make([]Position, len(p.byID))
+	for id, piece := range p.byID {
+		pos[id] = piece.CurrPos
+	}
+	return pos
+}
+
+func TestSeededPuzzlesAreIdentical(t *testing.T) {
+	file := newTestImage(t)
+	for _, cut := range []Cut{RectCut, JigsawCut} {
+		options := Options{Cut: cut, Rotation: true, Seed: 42}
+		p1 := NewPuzzle("p1", file, 4, 5, options, nil, newTestUsers())
+		p2 := NewPuzzle("p2", file, 4, 5, options, nil, newTestUsers())
+		if p1 == nil || p2 == nil {
+			t.Fatalf("cut %d: puzzle wasn't created", cut)
+		}
+		for id := range p1.byID {
+			piece1, piece2 := p1.byID[id], p2.byID[id]
+			if piece1.CurrPos != piece2.CurrPos || piece1.Rotation != piece2.Rotation {
+				t.Errorf("cut %d: piece %d is at %v turned %d, and %v turned %d", cut, id,
+					piece1.CurrPos, piece1.Rotation, piece2.CurrPos, piece2.Rotation)
+			}
+			if !reflect.DeepEqual(piece1.Edges, piece2.Edges) {
+				t.Errorf("cut %d: piece %d has edges %v and %v", cut, id, piece1.Edges, piece2.Edges)
+			}
+		}
+	}
+}
+
+func TestShuffleIsSeeded(t *testing.T) {
+	for shuffle := DerangeShuffle; shuffle <= EdgesSolvedShuffle; shuffle++ {
+		options := Options{Shuffle: shuffle, InPlace: 50, Distance: 1, Seed: 7}
+		p1 := newTestPuzzle(4, 5, options, newTestUsers())
+		p2 := newTestPuzzle(4, 5, options, newTestUsers())
+		p1.Shuffle()
+		p2.Shuffle()
+		if !reflect.DeepEqual(currPositions(p1), currPositions(p2)) {
+			t.Errorf("shuffle %d: same seed shuffled differently", shuffle)
+		}
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		// check fails the test if a piece of the 4*5 puzzle ended up
+		// somewhere the shuffle can't put it
+		check func(t *testing.T, p *Puzzle, piece *Piece)
+		// correct is how many pieces are left correct, or -1 if it depends
+		// on the seed
+		correct int
+	}{
+		{
+			name:    "derange",
+			options: Options{Shuffle: DerangeShuffle},
+			check:   func(*testing.T, *Puzzle, *Piece) {},
+			correct: 0,
+		},
+		{
+			name:    "partial",
+			options: Options{Shuffle: PartialShuffle, InPlace: 50},
+			check:   func(*testing.T, *Puzzle, *Piece) {},
+			correct: 10,
+		},
+		{
+			name:    "partial keeping every piece but one",
+			options: Options{Shuffle: PartialShuffle, InPlace: 99},
+			check:   func(*testing.T, *Puzzle, *Piece) {},
+			correct: 18,
+		},
+		{
+			name:    "local",
+			options: Options{Shuffle: LocalShuffle, Distance: 1},
+			check: func(t *testing.T, p *Puzzle, piece *Piece) {
+				dy, dx := piece.CurrPos.Y-piece.DestPos.Y, piece.CurrPos.X-piece.DestPos.X
+				if dy < -1 || dy > 1 || dx < -1 || dx > 1 {
+					t.Errorf("piece %d moved from %v to %v", piece.ID, piece.DestPos, piece.CurrPos)
+				}
+			},
+			correct: -1,
+		},
+		{
+			name:    "row",
+			options: Options{Shuffle: RowShuffle},
+			check: func(t *testing.T, p *Puzzle, piece *Piece) {
+				if piece.CurrPos.Y != piece.DestPos.Y {
+					t.Errorf("piece %d moved from %v to %v", piece.ID, piece.DestPos, piece.CurrPos)
+				}
+			},
+			correct: 0,
+		},
+		{
+			name:    "column",
+			options: Options{Shuffle: ColumnShuffle},
+			check: func(t *testing.T, p *Puzzle, piece *Piece) {
+				if piece.CurrPos.X != piece.DestPos.X {
+					t.Errorf("piece %d moved from %v to %v", piece.ID, piece.DestPos, piece.CurrPos)
+				}
+			},
+			correct: 0,
+		},
+		{
+			name:    "edges solved",
+			options: Options{Shuffle: EdgesSolvedShuffle},
+			check: func(t *testing.T, p *Puzzle, piece *Piece) {
+				if p.topology.Border(piece.DestPos) != piece.Correct() {
+					t.Errorf("piece %d that belongs at %v is at %v", piece.ID, piece.DestPos, piece.CurrPos)
+				}
+			},
+			correct: 14,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 20; seed++ {
+				test.options.Seed = seed
+				p := newTestPuzzle(4, 5, test.options, newTestUsers())
+				p.Shuffle()
+				for _, piece := range p.byID {
+					test.check(t, p, piece)
+				}
+				if p.Complete() {
+					t.Fatalf("seed %d: puzzle is still complete", seed)
+				}
+				if test.correct >= 0 && p.PiecesCorrect != test.correct {
+					t.Fatalf("seed %d: %d pieces correct, want %d", seed, p.PiecesCorrect, test.correct)
+				}
+				correct := 0
+				for _, piece := range p.byID {
+					if piece.Correct() {
+						correct++
+					}
+				}
+				if correct != p.PiecesCorrect {
+					t.Fatalf("seed %d: %d pieces correct, counted %d", seed, correct, p.PiecesCorrect)
+				}
+			}
+		})
+	}
+}
+
+func TestShuffleSmallBoards(t *testing.T) {
+	tests := []struct {
+		name         string
+		ySize, xSize int
+		options      Options
+		correct      int
+	}{
+		{"single piece", 1, 1, Options{Shuffle: PartialShuffle, InPlace: 50}, 1},
+		{"partial on two pieces", 1, 2, Options{Shuffle: PartialShuffle, InPlace: 90}, 0},
+		{"columns of one piece", 1, 3, Options{Shuffle: ColumnShuffle}, 0},
+		{"only edges", 2, 2, Options{Shuffle: EdgesSolvedShuffle}, 0},
+		{"local on one block", 1, 2, Options{Shuffle: LocalShuffle, Distance: 5}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPuzzle(test.ySize, test.xSize, test.options, newTestUsers())
+			p.Shuffle()
+			if p.PiecesCorrect != test.correct {
+				t.Errorf("%d pieces correct, want %d", p.PiecesCorrect, test.correct)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package game
+
+import (
+	"context"
+	"sync"
+)
+
+// Subscription is a handle to a callback registered on a live puzzle. The
+// callback stops being called once the subscription is closed, or once the
+// context it was subscribed with is cancelled
+type Subscription struct {
+	done        chan struct{}
+	closeOnce   sync.Once
+	unsubscribe func()
+}
+
+// newSubscription creates a subscription that calls unsubscribe when closed,
+// and closes itself when ctx is cancelled
+func newSubscription(ctx context.Context, unsubscribe func()) *Subscription {
+	s := &Subscription{done: make(chan struct{}), unsubscribe: unsubscribe}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.Close()
+			case <-s.done:
+			}
+		}()
+	}
+	return s
+}
+
+// Close unregisters the subscription's callback. It must not be called from
+// inside the callback itself
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.unsubscribe()
+	})
+}
+
+// Done returns a channel that is closed once the subscription is closed
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
@@ -0,0 +1,74 @@
+package game
+
+import "sort"
+
+// maxTeamName is the longest a team's name can be
+const maxTeamName = 32
+
+// teamColors are the colors teams are given, in the order they are formed
+var teamColors = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231",
+	"#911eb4", "#42d4f4", "#f032e6", "#bfef45",
+}
+
+// Team is a group of users playing a puzzle together. Score is the sum of the
+// points its members scored
+type Team struct {
+	Color   string   `json:"color"`
+	Members []string `json:"members"`
+	Score   int      `json:"score"`
+}
+
+// Results are the score of each user that played a puzzle, the score of each
+// team, and how many seconds the puzzle's clock ran for
+type Results struct {
+	Users   map[string]int `json:"users"`
+	Teams   map[string]int `json:"teams"`
+	Elapsed float64        `json:"elapsed"`
+}
+
+// validTeam checks that a team can be joined with the name
+func validTeam(name string) error {
+	if len(name) > maxTeamName {
+		return newError(ErrInvalidTeam, "team name is too long")
+	}
+	return nil
+}
+
+// checkTeam checks that a user can join a team, users stay on the first team
+// they join. An empty name keeps the user on the team they are on
+func (p *Puzzle) checkTeam(userID string, name string) error {
+	if err := validTeam(name); err != nil {
+		return err
+	}
+	if current, exists := p.teamOf[userID]; exists && name != "" && name != current {
+		return newError(ErrInvalidTeam, "user is already on another team")
+	}
+	return nil
+}
+
+// joinTeam adds a user to a team, forming the team if it doesn't exist yet
+func (p *Puzzle) joinTeam(userID string, name string) {
+	if _, exists := p.teamOf[userID]; exists || name == "" {
+		return
+	}
+	team, exists := p.Teams[name]
+	if !exists {
+		team = &Team{Color: teamColors[len(p.Teams)%len(teamColors)]}
+		p.Teams[name] = team
+	}
+	team.Members = append(team.Members, userID)
+	sort.Strings(team.Members)
+	p.teamOf[userID] = name
+}
+
+// copyTeams returns a deep copy of teams
+func copyTeams(teams map[string]*Team) map[string]*Team {
+	copied := make(map[string]*Team)
+	for name, team := range teams {
+		members := make([]string, len(team.Members))
+		copy(members, team.Members)
+		copied[name] = &Team{Color: team.Color, Members: members, Score: team.Score}
+	}
+	return copied
+}
@@ -0,0 +1,218 @@
+package game
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ilikerice123/puzzle/fs"
+	"github.com/ilikerice123/puzzle/picture"
+)
+
+const (
+	// timelapseFrames is about how many frames a timelapse has, however many
+	// updates the puzzle took
+	timelapseFrames = 100
+	// timelapseDelay is how long each frame is shown, in hundredths of a second
+	timelapseDelay = 10
+	// timelapseEndDelay is how long the finished puzzle is shown
+	timelapseEndDelay = 300
+)
+
+// TimelapseJob renders the timelapse of a puzzle in the background
+type TimelapseJob struct {
+	lock       sync.Mutex
+	frames     int
+	framesDone int
+	done       bool
+	err        error
+}
+
+// TimelapseProgress is how far along a timelapse job is
+type TimelapseProgress struct {
+	Frames     int    `json:"frames"`
+	FramesDone int    `json:"framesDone"`
+	Done       bool   `json:"done"`
+	Error      string `json:"error,omitempty"`
+}
+
+// timelapses are the timelapse jobs of every puzzle, by puzzle id
+var timelapses = struct {
+	sync.Mutex
+	jobs map[string]*TimelapseJob
+}{jobs: make(map[string]*TimelapseJob)}
+
+// StartTimelapse starts rendering the timelapse of a puzzle in GlobalPuzzlePool
+// to file in the background, and returns the job. If the timelapse is already
+// rendering or rendered, its existing job is returned instead. Jobs that failed
+// are started again
+func StartTimelapse(id string, file string) *TimelapseJob {
+	timelapses.Lock()
+	defer timelapses.Unlock()
+	if job, exists := timelapses.jobs[id]; exists && job.Progress().Error == "" {
+		return job
+	}
+
+	job := &TimelapseJob{}
+	timelapses.jobs[id] = job
+	go func() {
+		events, err := GlobalPuzzlePool.Events(id)
+		if err == nil {
+			err = RenderTimelapse(events, file, job.progress)
+		}
+		job.lock.Lock()
+		job.done = true
+		job.err = err
+		job.lock.Unlock()
+	}()
+	return job
+}
+
+// GetTimelapse returns the timelapse job of a puzzle, or nil if there isn't one
+func GetTimelapse(id string) *TimelapseJob {
+	timelapses.Lock()
+	defer timelapses.Unlock()
+	return timelapses.jobs[id]
+}
+
+// forgetTimelapse removes the timelapse job of a puzzle
+func forgetTimelapse(id string) {
+	timelapses.Lock()
+	defer timelapses.Unlock()
+	delete(timelapses.jobs, id)
+}
+
+// Progress returns how far along the job is
+func (j *TimelapseJob) Progress() TimelapseProgress {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	progress := TimelapseProgress{Frames: j.frames, FramesDone: j.framesDone, Done: j.done}
+	if j.err != nil {
+		progress.Error = j.err.Error()
+	}
+	return progress
+}
+
+// progress records that done out of frames frames were rendered
+func (j *TimelapseJob) progress(done int, frames int) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.framesDone = done
+	j.frames = frames
+}
+
+// RenderTimelapse replays a puzzle's log, and saves the board at evenly spaced
+// updates to file as an animated gif. progress is called after every frame
+// with how many frames are done, out of how many there are
+func RenderTimelapse(events []*Event, file string, progress func(done int, frames int)) error {
+	if len(events) == 0 || events[0].Genesis == nil {
+		return fmt.Errorf("log doesn't start with a genesis")
+	}
+
+	// pick which events to draw the board after, so the frames are spread
+	// evenly across the updates
+	updates := 0
+	for _, e := range events {
+		if len(e.Updates) > 0 {
+			updates = e.Updates[len(e.Updates)-1].ID + 1 - events[0].Genesis.NextUpdateID
+		}
+	}
+	every := updates / timelapseFrames
+	if every < 1 {
+		every = 1
+	}
+	sampled := make([]bool, len(events))
+	sampled[0] = true
+	next := events[0].Genesis.NextUpdateID + every
+	for i, e := range events {
+		if len(e.Updates) > 0 && e.Updates[len(e.Updates)-1].ID+1 >= next {
+			sampled[i] = true
+			next += every
+		}
+	}
+	sampled[len(events)-1] = true
+	frames := 0
+	for _, s := range sampled {
+		if s {
+			frames++
+		}
+	}
+
+	var timelapse *picture.Timelapse
+	var pieceWidth, pieceHeight int
+	var frameErr error
+	i, done := 0, 0
+	err := replay(events, func(p *Puzzle) bool {
+		defer func() { i++ }()
+		if !sampled[i] {
+			return true
+		}
+		if timelapse == nil {
+			pieceWidth, pieceHeight, frameErr = p.pieceSize()
+			if frameErr != nil {
+				return false
+			}
+			timelapse = p.newTimelapse(pieceWidth, pieceHeight)
+		}
+		delay := timelapseDelay
+		if i == len(events)-1 {
+			delay = timelapseEndDelay
+		}
+		frameErr = timelapse.AddFrame(p.placements(pieceWidth, pieceHeight), delay)
+		if frameErr != nil {
+			return false
+		}
+		done++
+		progress(done, frames)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if frameErr != nil {
+		return frameErr
+	}
+	return timelapse.Save(file)
+}
+
+// pieceSize returns how many pixels wide and high pieces are, not counting
+// their margin
+func (p *Puzzle) pieceSize() (int, int, error) {
+	img, err := fs.LoadImage(p.byID[0].ImageFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	size := img.Bounds().Size()
+	return size.X - 2*p.PieceMargin, size.Y - 2*p.PieceMargin, nil
+}
+
+// newTimelapse creates a timelapse the size of the puzzle's board, or its
+// table in free mode, with room around it for the tabs of pieces on the border
+func (p *Puzzle) newTimelapse(pieceWidth int, pieceHeight int) *picture.Timelapse {
+	cols, rows := p.topology.Size(p.YSize, p.XSize)
+	if p.Table != nil {
+		cols = p.Table.Max.X - p.Table.Min.X
+		rows = p.Table.Max.Y - p.Table.Min.Y
+	}
+	return picture.NewTimelapse(
+		int(cols*float64(pieceWidth))+2*p.PieceMargin,
+		int(rows*float64(pieceHeight))+2*p.PieceMargin,
+		pieceWidth,
+		pieceHeight)
+}
+
+// placements returns where every piece is drawn in a frame of a timelapse
+func (p *Puzzle) placements(pieceWidth int, pieceHeight int) []picture.Placement {
+	placements := make([]picture.Placement, 0, p.Size)
+	for _, piece := range p.byID {
+		x, y := p.topology.Origin(piece.CurrPos.Y, piece.CurrPos.X)
+		if piece.BoardPos != nil {
+			x, y = piece.BoardPos.X-p.Table.Min.X, piece.BoardPos.Y-p.Table.Min.Y
+		}
+		placements = append(placements, picture.Placement{
+			ImageFile: piece.ImageFile,
+			X:         x*float64(pieceWidth) + float64(p.PieceMargin),
+			Y:         y*float64(pieceHeight) + float64(p.PieceMargin),
+			Rotation:  piece.Rotation})
+	}
+	return placements
+}
@@ -0,0 +1,158 @@
+package game
+
+import "github.com/ilikerice123/puzzle/picture"
+
+// Layout is the shape of the pieces of a puzzle, and how they fit together
+type Layout int
+
+// layouts a puzzle can be created with
+const (
+	// RectLayout lays rectangles out in rows and columns
+	RectLayout Layout = iota
+	// HexLayout lays hexagons out in rows, where every odd row is shifted
+	// half a piece to the right
+	HexLayout
+	// TriangleLayout lays triangles out in rows, alternately pointing up and
+	// down, starting with one pointing up in the top left corner
+	TriangleLayout
+)
+
+// Topology is how the cells of a board are shaped and laid out. Cells are
+// still addressed by row and column, the topology decides which of them are
+// next to each other, and where they are drawn
+type Topology interface {
+	picture.Tiling
+
+	// Neighbours returns the cells that share a side with a cell
+	Neighbours(pos Position) []Position
+
+	// Border returns if a cell is on the border of the board
+	Border(pos Position) bool
+
+	// Corner returns if a cell is in a corner of the board
+	Corner(pos Position) bool
+
+	// SameShape returns if a piece that belongs in one cell fits in another
+	SameShape(a Position, b Position) bool
+}
+
+// newTopology returns the topology of a board of a layout
+func newTopology(layout Layout, ySize int, xSize int) Topology {
+	b := bounds{ySize: ySize, xSize: xSize}
+	switch layout {
+	case HexLayout:
+		return hexTopology{bounds: b}
+	case TriangleLayout:
+		return triangleTopology{bounds: b}
+	default:
+		return rectTopology{bounds: b}
+	}
+}
+
+// bounds is the size of a board, shared by every topology
+type bounds struct {
+	ySize int
+	xSize int
+}
+
+// inBounds returns the cells of offsets from a cell that are on the board
+func (b bounds) inBounds(pos Position, offsets []Position) []Position {
+	cells := make([]Position, 0, len(offsets))
+	for _, offset := range offsets {
+		cell := Position{Y: pos.Y + offset.Y, X: pos.X + offset.X}
+		if cell.Y >= 0 && cell.Y < b.ySize && cell.X >= 0 && cell.X < b.xSize {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// Corner returns if a cell is in the first or last row, and the first or
+// last column
+func (b bounds) Corner(pos Position) bool {
+	return (pos.Y == 0 || pos.Y == b.ySize-1) && (pos.X == 0 || pos.X == b.xSize-1)
+}
+
+// rectTopology is a board of rectangles
+type rectTopology struct {
+	picture.RectTiling
+	bounds
+}
+
+// rectOffsets are the cells next to a rectangle
+var rectOffsets = []Position{{Y: 0, X: -1}, {Y: 0, X: 1}, {Y: -1, X: 0}, {Y: 1, X: 0}}
+
+// Neighbours returns the cells left, right, above and below a cell
+func (t rectTopology) Neighbours(pos Position) []Position {
+	return t.inBounds(pos, rectOffsets)
+}
+
+// Border returns if a cell is in the first or last row or column
+func (t rectTopology) Border(pos Position) bool {
+	return len(t.Neighbours(pos)) < len(rectOffsets)
+}
+
+// SameShape returns true, every rectangle is the same shape
+func (rectTopology) SameShape(a Position, b Position) bool {
+	return true
+}
+
+// hexTopology is a board of hexagons, where odd rows are shifted right
+type hexTopology struct {
+	picture.HexTiling
+	bounds
+}
+
+// hexOffsets are the cells next to a hexagon in an even and an odd row
+var hexOffsets = [2][]Position{
+	{{Y: 0, X: -1}, {Y: 0, X: 1}, {Y: -1, X: -1}, {Y: -1, X: 0}, {Y: 1, X: -1}, {Y: 1, X: 0}},
+	{{Y: 0, X: -1}, {Y: 0, X: 1}, {Y: -1, X: 0}, {Y: -1, X: 1}, {Y: 1, X: 0}, {Y: 1, X: 1}},
+}
+
+// Neighbours returns the cells left and right of a cell, and the two above
+// and below it
+func (t hexTopology) Neighbours(pos Position) []Position {
+	return t.inBounds(pos, hexOffsets[pos.Y%2])
+}
+
+// Border returns if a cell has fewer than six neighbours
+func (t hexTopology) Border(pos Position) bool {
+	return len(t.Neighbours(pos)) < len(hexOffsets[0])
+}
+
+// SameShape returns true, every hexagon is the same shape
+func (hexTopology) SameShape(a Position, b Position) bool {
+	return true
+}
+
+// triangleTopology is a board of triangles pointing up and down
+type triangleTopology struct {
+	picture.TriangleTiling
+	bounds
+}
+
+// triangleOffsets are the cells next to a triangle pointing up and down
+var triangleOffsets = [2][]Position{
+	{{Y: 0, X: -1}, {Y: 0, X: 1}, {Y: 1, X: 0}},
+	{{Y: 0, X: -1}, {Y: 0, X: 1}, {Y: -1, X: 0}},
+}
+
+// Neighbours returns the cells left and right of a cell, and the one below
+// it if it points up, or above it if it points down
+func (t triangleTopology) Neighbours(pos Position) []Position {
+	if picture.PointsUp(pos.Y, pos.X) {
+		return t.inBounds(pos, triangleOffsets[0])
+	}
+	return t.inBounds(pos, triangleOffsets[1])
+}
+
+// Border returns if a cell has fewer than three neighbours
+func (t triangleTopology) Border(pos Position) bool {
+	return len(t.Neighbours(pos)) < len(triangleOffsets[0])
+}
+
+// SameShape returns if two triangles point the same way, since pieces can't
+// be turned over to fit a triangle pointing the other way
+func (triangleTopology) SameShape(a Position, b Position) bool {
+	return picture.PointsUp(a.Y, a.X) == picture.PointsUp(b.Y, b.X)
+}
@@ -0,0 +1,86 @@
+package game
+
+import "time"
+
+// swapRecord is a swap of a user that can be undone, along with what it
+// changed about their score
+type swapRecord struct {
+	piece1 *Piece
+	piece2 *Piece
+	// move is the move the swap was, it can't be undone once either piece
+	// was moved since
+	move   int
+	delta  int
+	points int
+	// streak is the user's streak from before the swap, and placed whether
+	// each piece was ever placed before it
+	streak int
+	placed [2]bool
+}
+
+// recordSwap remembers a swap of a user so they can undo it, given their
+// streak and whether the pieces were ever placed from before it
+func (p *Puzzle) recordSwap(
+	userID string,
+	piece1 *Piece,
+	piece2 *Piece,
+	delta int,
+	points int,
+	streak int,
+	placed [2]bool) {
+	p.lastSwaps[userID] = &swapRecord{
+		piece1: piece1,
+		piece2: piece2,
+		move:   p.touched[piece1.ID],
+		delta:  delta,
+		points: points,
+		streak: streak,
+		placed: placed}
+}
+
+// undo swaps back the last swap of a user, if neither piece was moved since,
+// and takes back the points it scored. Only swaps made by holding pieces in
+// grid mode without groups can be undone, not batches, rotations, or moves in
+// free mode
+func (p *Puzzle) undo(r Request) error {
+	if p.Mode == FreeMode {
+		return newError(ErrCantUndo, "moves can't be undone in free mode")
+	}
+	if err := p.checkJoined(r.UserID); err != nil {
+		return err
+	}
+	s, moved := p.lastSwaps[r.UserID]
+	if moved && s == nil {
+		return newError(ErrCantUndo, "only swaps can be undone")
+	}
+	if s == nil || p.touched[s.piece1.ID] != s.move || p.touched[s.piece2.ID] != s.move {
+		return newError(ErrNothingToUndo, "user has no swap to undo")
+	}
+	if s.piece1.HeldBy != "" || s.piece2.HeldBy != "" {
+		return newError(ErrPieceHeld, "piece is held by a user")
+	}
+
+	p.LastUpdated = time.Now()
+	delete(p.lastSwaps, r.UserID)
+	delta := p.swap(s.piece1, s.piece2)
+	p.PiecesCorrect += delta
+	p.moves++
+	for i, piece := range []*Piece{s.piece1, s.piece2} {
+		p.touched[piece.ID] = p.moves
+		p.correct[piece.ID] = piece.Correct()
+		p.placed[piece.ID] = s.placed[i]
+		if piece.Correct() {
+			p.lock(piece)
+		} else {
+			piece.Locked = false
+		}
+	}
+	p.streaks[r.UserID] = s.streak
+	p.CurrentUsers[r.UserID].LifetimePieces -= s.delta
+	p.award(r.UserID, -s.points)
+
+	update := p.newUpdate(UNDO, r.UserID, s.piece1.CurrPos, s.piece2.CurrPos, delta)
+	update.Points = -s.points
+	p.emit(update)
+	return nil
+}
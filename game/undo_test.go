@@ -0,0 +1,119 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newUndoPuzzle creates a 2*2 puzzle where only the piece at (1, 1) is
+// correct, and swapping the pieces at (0, 0) and (0, 1) puts one of them where
+// it belongs, joined by u1
+func newUndoPuzzle(t *testing.T, options Options) *Puzzle {
+	p := newTestPuzzle(2, 2, options, newTestUsers("u1", "u2"))
+	arrange(p,
+		[2]Position{{Y: 0, X: 0}, {Y: 0, X: 1}},
+		[2]Position{{Y: 0, X: 1}, {Y: 1, X: 0}})
+	do(t, p, Request{Action: JOIN, UserID: "u1", internal: true})
+	return p
+}
+
+// swap swaps two pieces with HOLD requests by a user
+func swap(t *testing.T, p *Puzzle, userID string, pos1 Position, pos2 Position) *Update {
+	do(t, p, Request{Action: HOLD, UserID: userID, PiecePos: pos1})
+	updates := do(t, p, Request{Action: HOLD, UserID: userID, PiecePos: pos2})
+	return updates[len(updates)-1]
+}
+
+func TestUndoRestoresScore(t *testing.T) {
+	options := Options{Scoring: &Ruleset{Placement: 3, Penalty: 2, FirstOnly: true, Streak: 1}}
+	p := newUndoPuzzle(t, options)
+	before := currPositions(p)
+
+	if update := swap(t, p, "u1", Position{Y: 0, X: 0}, Position{Y: 0, X: 1}); update.Points != 3 {
+		t.Fatalf("swap scored %d points, want 3", update.Points)
+	}
+	updates := do(t, p, Request{Action: UNDO, UserID: "u1"})
+	if len(updates) != 1 || updates[0].Action != UNDO || updates[0].Points != -3 || updates[0].Delta != -1 {
+		t.Fatalf("undo sent %+v", updates[0])
+	}
+	if !reflect.DeepEqual(currPositions(p), before) {
+		t.Errorf("pieces are at %v, want %v", currPositions(p), before)
+	}
+	if p.scores["u1"] != 0 || p.PiecesCorrect != 1 || p.streaks["u1"] != 0 {
+		t.Errorf("score is %d, pieces correct %d and streak %d after undo", p.scores["u1"], p.PiecesCorrect, p.streaks["u1"])
+	}
+	if lifetime := p.CurrentUsers["u1"].LifetimePieces; lifetime != 0 {
+		t.Errorf("user has %d lifetime pieces after undo", lifetime)
+	}
+	// the piece was never placed, so placing it again is worth the same
+	if update := swap(t, p, "u1", Position{Y: 0, X: 0}, Position{Y: 0, X: 1}); update.Points != 3 {
+		t.Errorf("swap after undo scored %d points, want 3", update.Points)
+	}
+}
+
+func TestUndoRestoresLocks(t *testing.T) {
+	p := newUndoPuzzle(t, Options{LockCorrect: true, Host: "u1"})
+	placed := p.at(Position{Y: 0, X: 0})
+
+	swap(t, p, "u1", Position{Y: 0, X: 0}, Position{Y: 0, X: 1})
+	if !placed.Locked {
+		t.Fatal("piece put where it belongs wasn't locked")
+	}
+	do(t, p, Request{Action: UNDO, UserID: "u1"})
+	if placed.Locked || placed.CurrPos != (Position{Y: 0, X: 0}) {
+		t.Errorf("undone piece is at %v, locked %t", placed.CurrPos, placed.Locked)
+	}
+
+	// an unlocked piece moved out of place is locked again when the move is
+	// undone
+	correct := p.at(Position{Y: 1, X: 1})
+	do(t, p, Request{Action: UNLOCK, UserID: "u1", PiecePos: correct.CurrPos})
+	swap(t, p, "u1", Position{Y: 1, X: 1}, Position{Y: 1, X: 0})
+	if correct.Locked || p.PiecesCorrect != 0 {
+		t.Fatalf("displaced piece is locked %t, with %d pieces correct", correct.Locked, p.PiecesCorrect)
+	}
+	updates := do(t, p, Request{Action: UNDO, UserID: "u1"})
+	if !correct.Locked || correct.CurrPos != (Position{Y: 1, X: 1}) {
+		t.Errorf("undone piece is at %v, locked %t", correct.CurrPos, correct.Locked)
+	}
+	if want := []Position{{Y: 1, X: 1}}; !reflect.DeepEqual(updates[0].Locked, want) {
+		t.Errorf("undo locked %v, want %v", updates[0].Locked, want)
+	}
+	if p.scores["u1"] != 0 || p.PiecesCorrect != 1 {
+		t.Errorf("score is %d, and pieces correct %d after undo", p.scores["u1"], p.PiecesCorrect)
+	}
+}
+
+func TestUndoAfterPiecesMoved(t *testing.T) {
+	p := newUndoPuzzle(t, Options{})
+	do(t, p, Request{Action: JOIN, UserID: "u2", internal: true})
+	swap(t, p, "u1", Position{Y: 0, X: 0}, Position{Y: 0, X: 1})
+	swap(t, p, "u2", Position{Y: 0, X: 1}, Position{Y: 1, X: 0})
+
+	_, err := p.Do(Request{Action: UNDO, UserID: "u1"})
+	if perr, ok := err.(*Error); !ok || perr.Code != ErrNothingToUndo {
+		t.Fatalf("error is %v, want %s", err, ErrNothingToUndo)
+	}
+}
+
+func TestUndoOnlySwaps(t *testing.T) {
+	p := newUndoPuzzle(t, Options{})
+	swap(t, p, "u1", Position{Y: 0, X: 0}, Position{Y: 0, X: 1})
+	do(t, p, Request{Action: BATCH, UserID: "u1", PiecePos: Position{Y: 1, X: 0}, Pieces: []Position{{Y: 1, X: 1}}})
+	if _, err := p.Do(Request{Action: UNDO, UserID: "u1"}); CodeOf(err) != ErrCantUndo {
+		t.Errorf("undoing a batch failed with %v, want %s", err, ErrCantUndo)
+	}
+
+	p = newUndoPuzzle(t, Options{Groups: true})
+	swap(t, p, "u1", Position{Y: 0, X: 0}, Position{Y: 0, X: 1})
+	if _, err := p.Do(Request{Action: UNDO, UserID: "u1"}); CodeOf(err) != ErrCantUndo {
+		t.Errorf("undoing a swap with groups failed with %v, want %s", err, ErrCantUndo)
+	}
+
+	p = newTestPuzzle(2, 2, Options{Mode: FreeMode}, newTestUsers("u1"))
+	p.Shuffle()
+	do(t, p, Request{Action: JOIN, UserID: "u1", internal: true})
+	if _, err := p.Do(Request{Action: UNDO, UserID: "u1"}); CodeOf(err) != ErrCantUndo {
+		t.Errorf("undoing in free mode failed with %v, want %s", err, ErrCantUndo)
+	}
+}
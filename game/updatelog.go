@@ -0,0 +1,49 @@
+package game
+
+// UpdateLog is a bounded ring buffer holding the most recent updates of a
+// puzzle, so clients that reconnect can be replayed the updates they missed
+type UpdateLog struct {
+	updates []*Update
+	start   int
+	count   int
+	nextID  int
+}
+
+// NewUpdateLog creates an update log that holds at most size updates
+func NewUpdateLog(size int) *UpdateLog {
+	return &UpdateLog{updates: make([]*Update, size)}
+}
+
+// Append adds an update to the log, evicting the oldest update if it is full
+func (l *UpdateLog) Append(u *Update) {
+	l.nextID = u.ID + 1
+	if l.count < len(l.updates) {
+		l.updates[(l.start+l.count)%len(l.updates)] = u
+		l.count++
+		return
+	}
+	l.updates[l.start] = u
+	l.start = (l.start + 1) % len(l.updates)
+}
+
+// Since returns every update with an id greater than id. The second return
+// value is false if some of those updates have already been evicted, or if id
+// is newer than anything in the log, in which case the caller has to fall
+// back to a full snapshot
+func (l *UpdateLog) Since(id int) ([]*Update, bool) {
+	if id < -1 || id >= l.nextID {
+		return nil, false
+	}
+	if id == l.nextID-1 {
+		return []*Update{}, true
+	}
+	if l.count == 0 || id+1 < l.updates[l.start].ID {
+		return nil, false
+	}
+
+	missed := make([]*Update, 0, l.nextID-id-1)
+	for i := id + 1 - l.updates[l.start].ID; i < l.count; i++ {
+		missed = append(missed, l.updates[(l.start+i)%len(l.updates)])
+	}
+	return missed, true
+}
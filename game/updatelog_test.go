@@ -0,0 +1,69 @@
+package game
+
+import "testing"
+
+// newTestLog creates an update log of size, with updates first to last
+// appended to it
+func newTestLog(size int, first int, last int) *UpdateLog {
+	l := NewUpdateLog(size)
+	for id := first; id <= last; id++ {
+		l.Append(&Update{ID: id})
+	}
+	return l
+}
+
+func TestUpdateLogSince(t *testing.T) {
+	tests := []struct {
+		name  string
+		log   *UpdateLog
+		since int
+		// want is the ids of the updates returned, nil if the log can't
+		// return them
+		want []int
+	}{
+		{"empty from the start", newTestLog(3, 0, -1), -1, []int{}},
+		{"empty past the end", newTestLog(3, 0, -1), 0, nil},
+		{"not full from the start", newTestLog(3, 0, 1), -1, []int{0, 1}},
+		{"full from the start", newTestLog(3, 0, 2), -1, []int{0, 1, 2}},
+		{"evicted from the start", newTestLog(3, 0, 4), -1, nil},
+		{"evicted just before the oldest", newTestLog(3, 0, 4), 0, nil},
+		{"from the oldest", newTestLog(3, 0, 4), 1, []int{2, 3, 4}},
+		{"from the middle", newTestLog(3, 0, 4), 3, []int{4}},
+		{"up to date", newTestLog(3, 0, 4), 4, []int{}},
+		{"past the end", newTestLog(3, 0, 4), 5, nil},
+		{"before the first update", newTestLog(3, 0, 4), -2, nil},
+		{"wrapped around twice", newTestLog(3, 0, 7), 4, []int{5, 6, 7}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updates, ok := test.log.Since(test.since)
+			if ok != (test.want != nil) {
+				t.Fatalf("Since(%d) returned %t", test.since, ok)
+			}
+			if len(updates) != len(test.want) {
+				t.Fatalf("Since(%d) returned %d updates, want %v", test.since, len(updates), test.want)
+			}
+			for i, update := range updates {
+				if update.ID != test.want[i] {
+					t.Errorf("Since(%d) returned update %d at %d, want %d", test.since, update.ID, i, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateLogSinceRestored(t *testing.T) {
+	// a restored puzzle's log starts empty, at the update it was saved at
+	l := NewUpdateLog(3)
+	l.nextID = 10
+	if updates, ok := l.Since(9); !ok || len(updates) != 0 {
+		t.Errorf("Since(9) returned %d updates and %t, want none", len(updates), ok)
+	}
+	if _, ok := l.Since(8); ok {
+		t.Error("Since(8) returned updates from before the puzzle was restored")
+	}
+	l.Append(&Update{ID: 10})
+	if updates, ok := l.Since(9); !ok || len(updates) != 1 || updates[0].ID != 10 {
+		t.Errorf("Since(9) returned %d updates and %t, want update 10", len(updates), ok)
+	}
+}
@@ -1,6 +1,7 @@
 package game
 
 import (
+	"sync"
 	"time"
 
 	"github.com/ilikerice123/puzzle/store"
@@ -9,18 +10,27 @@ import (
 // GlobalUserPool represents all the players
 var GlobalUserPool UserPoolBase
 
+// missingTTL is how long users that aren't in the store are remembered to be
+// missing, before they are looked up again
+const missingTTL = time.Minute
+
 // UserPoolBase is the interface for a pool of users
 type UserPoolBase interface {
 	AddUser(*store.User)
 
 	GetUser(string) *store.User
 
+	LoadUser(string) *store.User
+
 	Prune()
 }
 
 // UserPool implements UserPoolBase
 type UserPool struct {
 	users map[string]*store.User
+	// missing are when users that weren't in the store were looked up
+	missing map[string]time.Time
+	lock    sync.RWMutex
 }
 
 // InitUserPool assigns value to globalUserPool
@@ -30,7 +40,7 @@ func InitUserPool() {
 
 // NewUserPool creates a new user pool
 func NewUserPool() *UserPool {
-	p := &UserPool{users: make(map[string]*store.User)}
+	p := &UserPool{users: make(map[string]*store.User), missing: make(map[string]time.Time)}
 	scheduler := time.NewTicker(12 * time.Hour)
 	go func() {
 		for range scheduler.C {
@@ -42,15 +52,53 @@ func NewUserPool() *UserPool {
 
 // AddUser adds a user to the pool
 func (p *UserPool) AddUser(u *store.User) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	p.users[u.ID] = u
+	delete(p.missing, u.ID)
 	return
 }
 
-// GetUser gets a user from the pool
+// GetUser gets a user from the pool, without looking it up in the store, so
+// puzzles never wait on it
 func (p *UserPool) GetUser(id string) *store.User {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	return p.users[id]
 }
 
+// LoadUser gets a user from the pool, loading it from the store if it isn't
+// in the pool, like the players of restored puzzles after a restart. Users
+// that aren't in the store either are remembered to be missing for a while
+func (p *UserPool) LoadUser(id string) *store.User {
+	p.lock.RLock()
+	u, exists := p.users[id]
+	missingSince, missing := p.missing[id]
+	p.lock.RUnlock()
+	if exists {
+		return u
+	}
+	if missing && time.Since(missingSince) < missingTTL {
+		return nil
+	}
+	stored, err := store.GetUser(id)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if u, exists := p.users[id]; exists {
+		// added by someone else in the meantime
+		return u
+	}
+	if err == store.ErrUserNotFound {
+		p.missing[id] = time.Now()
+	}
+	if err != nil {
+		return nil
+	}
+	stored.PieceCount = make(map[string]int)
+	p.users[id] = &stored
+	return &stored
+}
+
 // AuthUser authenticates a user from the pool
 func (p *UserPool) AuthUser(name string, password string) {
 
@@ -58,12 +106,16 @@ func (p *UserPool) AuthUser(name string, password string) {
 
 // DeleteUser deletes a user from the pool
 func (p *UserPool) DeleteUser(id string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	delete(p.users, id)
 	return
 }
 
 // Prune removes all puzzles from pieceCount that no longer exist
 func (p *UserPool) Prune() {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
 	for _, user := range p.users {
 		for puzzleID := range user.PieceCount {
 			if puzzle := GlobalPuzzlePool.GetPuzzle(puzzleID); puzzle == nil {
@@ -5,6 +5,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -27,11 +29,40 @@ func main() {
 		}
 	}
 
+	// make directory to store puzzles, so they survive restarts
+	puzzleStore, err := game.NewFilePuzzleStore("data/puzzles")
+	if err != nil {
+		log.Fatalf("unable to create data directory to store puzzles")
+	}
+	puzzleEvents, err := game.NewFileEventStore("data/logs")
+	if err != nil {
+		log.Fatalf("unable to create data directory to log puzzles")
+	}
+	puzzleResults, err := game.NewFileResultStore("data/results")
+	if err != nil {
+		log.Fatalf("unable to create data directory to archive results")
+	}
+
+	// init the user store first, players of restored puzzles are loaded from
+	// it when they rejoin
+	if err := store.InitStore(); err != nil {
+		log.Printf("unable to connect to the user store: %s", err.Error())
+	}
+
+	// clients that fall behind are resynced, unless SLOW_CLIENTS is set to
+	// disconnect them instead
+	if policy := os.Getenv("SLOW_CLIENTS"); policy != "" {
+		slowClients, err := api.ParseSlowClientPolicy(policy)
+		if err != nil {
+			log.Fatalf("unable to set the slow client policy: %s", err.Error())
+		}
+		api.SlowClients = slowClients
+	}
+
 	// init global pools and websocket upgrader
 	game.InitUserPool()
-	game.InitPuzzlePool()
+	game.InitPuzzlePool(puzzleStore, puzzleEvents, puzzleResults)
 	api.InitUpgrader()
-	store.InitStore()
 
 	r := mux.NewRouter()
 	apiRouter := r.PathPrefix("/api").Subrouter()
@@ -51,6 +82,16 @@ func main() {
 		WriteTimeout: 24 * time.Hour,
 		ReadTimeout:  24 * time.Hour,
 	}
+	// save every puzzle before shutting down
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		log.Println("saving puzzles before shutting down")
+		game.GlobalPuzzlePool.SaveAll()
+		os.Exit(0)
+	}()
+
 	log.Fatal(server.ListenAndServe())
 	wait := make(chan int, 1)
 	<-wait
@@ -0,0 +1,226 @@
+package picture
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"path"
+
+	"github.com/ilikerice123/puzzle/fs"
+)
+
+// Edge is the shape of one side of a jigsaw piece
+type Edge int
+
+// shapes of a side of a piece
+const (
+	// Blank is a hole the tab of the neighbouring piece fits into
+	Blank Edge = iota - 1
+	// Flat is a straight side on the border of the puzzle
+	Flat
+	// Tab is a knob that fits into the blank of the neighbouring piece
+	Tab
+)
+
+// sides of a piece, used to index Edges
+const (
+	Top = iota
+	Right
+	Bottom
+	Left
+)
+
+// Edges are the shapes of the top, right, bottom and left sides of a piece
+type Edges [4]Edge
+
+// Fits returns if side of e fits against the opposite side of other, when
+// other is placed next to it on that side
+func (e Edges) Fits(side int, other Edges) bool {
+	return e[side] != Flat && e[side] == -other[(side+2)%4]
+}
+
+const (
+	// radius of tabs, as a fraction of the smaller side of a piece
+	tabRadius = 0.17
+	// how far past the seam the centre of a tab is, as a fraction of its radius
+	tabOffset = 0.6
+	// how far the centre of a tab can be from the middle of a seam, as a
+	// fraction of the length of the seam
+	tabJitter = 0.1
+)
+
+// seam is the shared side of two neighbouring pieces, a and b, where a is
+// above or to the left of b
+type seam struct {
+	// tab is Tab if a has the tab, and Blank if b does
+	tab Edge
+	// where the tab is along the seam, as a fraction of its length
+	along float64
+}
+
+// jigsaw describes how an image is cut into ySize*xSize jigsaw pieces
+type jigsaw struct {
+	pieceHeight int
+	pieceWidth  int
+	radius      float64
+	// vertical[i][j] is the seam between piece (i, j) and (i, j+1)
+	vertical [][]seam
+	// horizontal[i][j] is the seam between piece (i, j) and (i+1, j)
+	horizontal [][]seam
+}
+
+// newJigsaw creates random seams for a jigsaw cut
+func newJigsaw(ySize int, xSize int, pieceHeight int, pieceWidth int, rng *rand.Rand) *jigsaw {
+	randomSeam := func() seam {
+		s := seam{tab: Tab, along: 0.5 + tabJitter*(2*rng.Float64()-1)}
+		if rng.Intn(2) == 0 {
+			s.tab = Blank
+		}
+		return s
+	}
+	j := &jigsaw{
+		pieceHeight: pieceHeight,
+		pieceWidth:  pieceWidth,
+		radius:      tabRadius * math.Min(float64(pieceHeight), float64(pieceWidth)),
+		vertical:    make([][]seam, ySize),
+		horizontal:  make([][]seam, ySize-1)}
+	for y := range j.vertical {
+		j.vertical[y] = make([]seam, xSize-1)
+		for x := range j.vertical[y] {
+			j.vertical[y][x] = randomSeam()
+		}
+	}
+	for y := range j.horizontal {
+		j.horizontal[y] = make([]seam, xSize)
+		for x := range j.horizontal[y] {
+			j.horizontal[y][x] = randomSeam()
+		}
+	}
+	return j
+}
+
+// margin returns how many pixels tabs can stick out past the sides of a piece
+func (j *jigsaw) margin() int {
+	return int(math.Ceil(j.radius*(1+tabOffset))) + 1
+}
+
+// edges returns the shape of each side of piece (y, x)
+func (j *jigsaw) edges(y int, x int) Edges {
+	e := Edges{Flat, Flat, Flat, Flat}
+	if y > 0 {
+		e[Top] = -j.horizontal[y-1][x].tab
+	}
+	if x < len(j.vertical[y]) {
+		e[Right] = j.vertical[y][x].tab
+	}
+	if y < len(j.horizontal) {
+		e[Bottom] = j.horizontal[y][x].tab
+	}
+	if x > 0 {
+		e[Left] = -j.vertical[y][x-1].tab
+	}
+	return e
+}
+
+// tabCentre returns where the centre of the tab of a seam is, in pixels
+// relative to the top left corner of piece (y, x), given the side of the
+// piece the seam is on. The centre is past the seam, on the side of the piece
+// with the blank
+func (j *jigsaw) tabCentre(y int, x int, side int) (float64, float64) {
+	w, h := float64(j.pieceWidth), float64(j.pieceHeight)
+	offset := tabOffset * j.radius
+	switch side {
+	case Top:
+		s := j.horizontal[y-1][x]
+		return s.along * w, float64(s.tab) * offset
+	case Right:
+		s := j.vertical[y][x]
+		return w + float64(s.tab)*offset, s.along * h
+	case Bottom:
+		s := j.horizontal[y][x]
+		return s.along * w, h + float64(s.tab)*offset
+	default:
+		s := j.vertical[y][x-1]
+		return float64(s.tab) * offset, s.along * h
+	}
+}
+
+// inside returns if the pixel at (px, py), relative to the top left corner of
+// piece (y, x), is part of the piece
+func (j *jigsaw) inside(y int, x int, edges Edges, px float64, py float64) bool {
+	in := px >= 0 && py >= 0 && px < float64(j.pieceWidth) && py < float64(j.pieceHeight)
+	for side, edge := range edges {
+		if edge == Flat {
+			continue
+		}
+		cx, cy := j.tabCentre(y, x, side)
+		inTab := math.Hypot(px-cx, py-cy) <= j.radius
+		if edge == Tab && inTab {
+			return true
+		}
+		if edge == Blank && inTab {
+			in = false
+		}
+	}
+	return in
+}
+
+// CutJigsaw cuts an image into ySize*xSize jigsaw pieces with random tabs and
+// blanks, saved as pngs that are transparent outside of the piece. Every png
+// has margin extra pixels on each side for tabs to stick out into. Returns the
+// file names and the edges of each piece, and the margin. The tabs are placed
+// using rng, so the same rng cuts the same pieces
+func CutJigsaw(
+	filename string,
+	ySize int,
+	xSize int,
+	rng *rand.Rand) ([][]string, [][]Edges, int, error) {
+	img, err := fs.LoadImage(filename)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	height, width := NormalizeImage(img, ySize, xSize)
+	cut := newJigsaw(ySize, xSize, height/ySize, width/xSize, rng)
+	margin := cut.margin()
+	bounds := img.Bounds()
+
+	imageNames := make([][]string, ySize)
+	edges := make([][]Edges, ySize)
+	for i := 0; i < ySize; i++ {
+		imageNames[i] = make([]string, xSize)
+		edges[i] = make([]Edges, xSize)
+		for j := 0; j < xSize; j++ {
+			edges[i][j] = cut.edges(i, j)
+			dst := image.NewNRGBA(image.Rect(0, 0, cut.pieceWidth+2*margin, cut.pieceHeight+2*margin))
+			for py := 0; py < dst.Rect.Dy(); py++ {
+				for px := 0; px < dst.Rect.Dx(); px++ {
+					// position relative to the top left corner of the piece
+					relX, relY := px-margin, py-margin
+					imgX := bounds.Min.X + j*cut.pieceWidth + relX
+					imgY := bounds.Min.Y + i*cut.pieceHeight + relY
+					if imgX < bounds.Min.X || imgY < bounds.Min.Y ||
+						imgX >= bounds.Min.X+width || imgY >= bounds.Min.Y+height {
+						continue
+					}
+					if cut.inside(i, j, edges[i][j], float64(relX)+0.5, float64(relY)+0.5) {
+						dst.Set(px, py, img.At(imgX, imgY))
+					} else {
+						dst.Set(px, py, color.Transparent)
+					}
+				}
+			}
+
+			ext := path.Ext(filename)
+			name := filename[0 : len(filename)-len(ext)]
+			fileName := fmt.Sprintf("%s_%d_%d.png", name, i, j)
+
+			if err := fs.SavePNG(fileName, dst); err != nil {
+				return nil, nil, 0, err
+			}
+			imageNames[i][j] = fileName
+		}
+	}
+	return imageNames, edges, margin, nil
+}
@@ -0,0 +1,159 @@
+package picture
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"path"
+
+	"github.com/ilikerice123/puzzle/fs"
+)
+
+// Tiling is how an image is cut into rows of tiles. Positions and sizes are
+// measured in tiles, where the box around every tile is 1*1
+type Tiling interface {
+	// Origin returns where the top left corner of the box around tile (y, x)
+	// is on the image
+	Origin(y int, x int) (float64, float64)
+
+	// Inside returns if the point (px, py), relative to the top left corner
+	// of the box around tile (y, x), is part of the tile
+	Inside(y int, x int, px float64, py float64) bool
+
+	// Size returns how wide and high an image cut into ySize*xSize tiles is
+	Size(ySize int, xSize int) (float64, float64)
+}
+
+// RectTiling cuts an image into rows and columns of rectangles
+type RectTiling struct{}
+
+// Origin returns where tile (y, x) is on the image
+func (RectTiling) Origin(y int, x int) (float64, float64) {
+	return float64(x), float64(y)
+}
+
+// Inside returns true, rectangles fill their box
+func (RectTiling) Inside(y int, x int, px float64, py float64) bool {
+	return true
+}
+
+// Size returns xSize*ySize
+func (RectTiling) Size(ySize int, xSize int) (float64, float64) {
+	return float64(xSize), float64(ySize)
+}
+
+// HexTiling cuts an image into rows of hexagons pointing up, where every odd
+// row is shifted half a hexagon to the right, and rows overlap by a quarter
+type HexTiling struct{}
+
+// Origin returns where the box around hexagon (y, x) is on the image
+func (HexTiling) Origin(y int, x int) (float64, float64) {
+	return float64(x) + 0.5*float64(y%2), 0.75 * float64(y)
+}
+
+// Inside returns if a point is inside the hexagon, which has its top and
+// bottom corners in the middle of its box, and its sides on the sides of it
+func (HexTiling) Inside(y int, x int, px float64, py float64) bool {
+	if px < 0 || px > 1 || py < 0 || py > 1 {
+		return false
+	}
+	dx := px - 0.5
+	if dx < 0 {
+		dx = -dx
+	}
+	// the slanted sides go from the middle of the top to a quarter of the
+	// way down each side
+	return py >= 0.5*dx && py <= 1-0.5*dx
+}
+
+// Size returns how wide and high ySize rows of xSize hexagons are
+func (HexTiling) Size(ySize int, xSize int) (float64, float64) {
+	width := float64(xSize)
+	if ySize > 1 {
+		width += 0.5
+	}
+	return width, 0.75*float64(ySize-1) + 1
+}
+
+// TriangleTiling cuts an image into rows of triangles, alternately pointing
+// up and down, starting with one pointing up in the top left corner
+type TriangleTiling struct{}
+
+// Origin returns where the box around triangle (y, x) is on the image
+func (TriangleTiling) Origin(y int, x int) (float64, float64) {
+	return 0.5 * float64(x), float64(y)
+}
+
+// Inside returns if a point is inside the triangle
+func (TriangleTiling) Inside(y int, x int, px float64, py float64) bool {
+	if px < 0 || px > 1 || py < 0 || py > 1 {
+		return false
+	}
+	dx := px - 0.5
+	if dx < 0 {
+		dx = -dx
+	}
+	if PointsUp(y, x) {
+		return py >= 2*dx
+	}
+	return 1-py >= 2*dx
+}
+
+// Size returns how wide and high ySize rows of xSize triangles are
+func (TriangleTiling) Size(ySize int, xSize int) (float64, float64) {
+	return 0.5 * float64(xSize+1), float64(ySize)
+}
+
+// PointsUp returns if triangle (y, x) of a triangle tiling points up
+func PointsUp(y int, x int) bool {
+	return (y+x)%2 == 0
+}
+
+// CutTiles cuts an image into ySize*xSize tiles of a tiling, saved as pngs that
+// are the size of the box around a tile, and transparent outside of it.
+// Returns the file names of the tiles
+func CutTiles(filename string, tiling Tiling, ySize int, xSize int) ([][]string, error) {
+	img, err := fs.LoadImage(filename)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	width, height := tiling.Size(ySize, xSize)
+	tileWidth := int(float64(bounds.Dx()) / width)
+	tileHeight := int(float64(bounds.Dy()) / height)
+	if tileWidth == 0 || tileHeight == 0 {
+		return nil, fmt.Errorf("image is too small to cut into %d*%d tiles", ySize, xSize)
+	}
+
+	imageNames := make([][]string, ySize)
+	for i := 0; i < ySize; i++ {
+		imageNames[i] = make([]string, xSize)
+		for j := 0; j < xSize; j++ {
+			originX, originY := tiling.Origin(i, j)
+			minX := bounds.Min.X + int(originX*float64(tileWidth))
+			minY := bounds.Min.Y + int(originY*float64(tileHeight))
+			dst := image.NewNRGBA(image.Rect(0, 0, tileWidth, tileHeight))
+			for py := 0; py < tileHeight; py++ {
+				for px := 0; px < tileWidth; px++ {
+					relX := (float64(px) + 0.5) / float64(tileWidth)
+					relY := (float64(py) + 0.5) / float64(tileHeight)
+					if tiling.Inside(i, j, relX, relY) {
+						dst.Set(px, py, img.At(minX+px, minY+py))
+					} else {
+						dst.Set(px, py, color.Transparent)
+					}
+				}
+			}
+
+			ext := path.Ext(filename)
+			name := filename[0 : len(filename)-len(ext)]
+			fileName := fmt.Sprintf("%s_%d_%d.png", name, i, j)
+
+			if err := fs.SavePNG(fileName, dst); err != nil {
+				return nil, err
+			}
+			imageNames[i][j] = fileName
+		}
+	}
+	return imageNames, nil
+}
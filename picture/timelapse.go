@@ -0,0 +1,126 @@
+package picture
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+
+	"github.com/disintegration/gift"
+	"github.com/ilikerice123/puzzle/fs"
+)
+
+// timelapseWidth is the widest a frame of a timelapse can be, larger boards
+// are scaled down to fit
+const timelapseWidth = 480
+
+// background is the colour of the board behind the pieces
+var background = color.RGBA{R: 0x30, G: 0x30, B: 0x30, A: 0xff}
+
+// Placement is where a piece is drawn in a frame of a timelapse
+type Placement struct {
+	ImageFile string
+	// X and Y are the top left corner of the cell of the piece, in pixels
+	X float64
+	Y float64
+	// Rotation is how many quarter turns clockwise the piece is turned
+	Rotation int
+}
+
+// pieceKey identifies a piece image as it is drawn
+type pieceKey struct {
+	file     string
+	rotation int
+}
+
+// Timelapse is an animated gif of a puzzle being solved, built a frame at a
+// time
+type Timelapse struct {
+	width       int
+	height      int
+	pieceWidth  int
+	pieceHeight int
+	scale       float64
+	// pieces are piece images that were already loaded, scaled and rotated
+	pieces map[pieceKey]image.Image
+	anim   gif.GIF
+}
+
+// NewTimelapse creates a timelapse of a board that is width*height pixels,
+// with pieces that are pieceWidth*pieceHeight pixels, not counting the margin
+// jigsaw pieces have around them
+func NewTimelapse(width int, height int, pieceWidth int, pieceHeight int) *Timelapse {
+	scale := 1.0
+	if width > timelapseWidth {
+		scale = float64(timelapseWidth) / float64(width)
+	}
+	return &Timelapse{
+		width:       int(float64(width) * scale),
+		height:      int(float64(height) * scale),
+		pieceWidth:  pieceWidth,
+		pieceHeight: pieceHeight,
+		scale:       scale,
+		pieces:      make(map[pieceKey]image.Image)}
+}
+
+// AddFrame draws the pieces where they are placed, and adds them as a frame
+// that is shown for delay hundredths of a second
+func (t *Timelapse) AddFrame(placements []Placement, delay int) error {
+	canvas := image.NewRGBA(image.Rect(0, 0, t.width, t.height))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{C: background}, image.Point{}, draw.Src)
+	for _, placement := range placements {
+		img, err := t.piece(placement.ImageFile, placement.Rotation)
+		if err != nil {
+			return err
+		}
+		// pieces are centred on their cell, so rotated pieces and their
+		// margins line up
+		size := img.Bounds().Size()
+		centreX := (placement.X + float64(t.pieceWidth)/2) * t.scale
+		centreY := (placement.Y + float64(t.pieceHeight)/2) * t.scale
+		min := image.Pt(int(centreX)-size.X/2, int(centreY)-size.Y/2)
+		draw.Draw(canvas, image.Rectangle{Min: min, Max: min.Add(size)}, img, img.Bounds().Min, draw.Over)
+	}
+
+	frame := image.NewPaletted(canvas.Bounds(), palette.Plan9)
+	draw.FloydSteinberg.Draw(frame, frame.Bounds(), canvas, image.Point{})
+	t.anim.Image = append(t.anim.Image, frame)
+	t.anim.Delay = append(t.anim.Delay, delay)
+	return nil
+}
+
+// Save saves the timelapse to a file as an animated gif
+func (t *Timelapse) Save(filename string) error {
+	return fs.SaveGIF(filename, &t.anim)
+}
+
+// piece returns the image of a piece, scaled down like the board and turned
+// rotation quarter turns clockwise
+func (t *Timelapse) piece(file string, rotation int) (image.Image, error) {
+	key := pieceKey{file, rotation % 4}
+	if img, exists := t.pieces[key]; exists {
+		return img, nil
+	}
+	src, err := fs.LoadImage(file)
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	filter := gift.New(gift.Resize(
+		int(float64(bounds.Dx())*t.scale),
+		int(float64(bounds.Dy())*t.scale),
+		gift.LinearResampling))
+	switch key.rotation {
+	case 1:
+		filter.Add(gift.Rotate270())
+	case 2:
+		filter.Add(gift.Rotate180())
+	case 3:
+		filter.Add(gift.Rotate90())
+	}
+	dst := image.NewNRGBA(filter.Bounds(bounds))
+	filter.Draw(dst, src)
+	t.pieces[key] = dst
+	return dst, nil
+}
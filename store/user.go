@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -40,6 +41,15 @@ func NewUser(name string, password string) *User {
 // storeClient is the mongoClient
 var userCollection *mongo.Collection
 
+// errNotInitialized is returned by lookups made before the store is initialized
+var errNotInitialized = errors.New("store is not initialized")
+
+// ErrUserNotFound is returned when looking up a user that isn't in the store
+var ErrUserNotFound = errors.New("user not found")
+
+// lookupTimeout is how long looking up a user can take
+const lookupTimeout = 5 * time.Second
+
 // InitStore inits the mongoDB storage
 func InitStore() error {
 	connString := os.Getenv("MONGODB_PUZZLE_CONN_STRING")
@@ -67,8 +77,16 @@ func UpdateUser(u *User) error {
 
 // GetUser retrieves a user from mongodb based on id
 func GetUser(id string) (u User, err error) {
-	err = userCollection.FindOne(context.TODO(), bson.M{"id": id}).Decode(&u)
-	fmt.Print(err)
+	if userCollection == nil {
+		err = errNotInitialized
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	err = userCollection.FindOne(ctx, bson.M{"id": id}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		err = ErrUserNotFound
+	}
 	return
 }
 